@@ -16,15 +16,6 @@ import (
 // the map key is the uuid
 var users = map[string]models.User{}
 
-// create a database of refresh tokens
-// map key is the jti (json token identifier)
-// the val doesn't represent anything but could be used to hold "valid", "revoked", etc.
-var refreshTokens map[string]string
-
-func InitDB() {
-	refreshTokens = make(map[string]string)
-}
-
 // password is hashed before getting here
 func StoreUser(username string, password string, role string) (uuid string, err error) {
 	uuid, err = randomstrings.GenerateRandomString(32)
@@ -82,35 +73,6 @@ func FetchUserByUsername(username string) (models.User, string, error) {
 	return models.User{}, "", errors.New("User not found that matches given username")
 }
 
-func StoreRefreshToken() (jti string, err error) {
-	jti, err = randomstrings.GenerateRandomString(32)
-	if err != nil {
-		return jti, err
-	}
-
-	// check to make sure our jti is unique
-	for refreshTokens[jti] != "" {
-		jti, err = randomstrings.GenerateRandomString(32)
-		if err != nil {
-			return jti, err
-		}
-	}
-
-	refreshTokens[jti] = "valid"
-
-	return jti, err
-}
-
-func DeleteRefreshToken(jti string) error {
-	delete(refreshTokens, jti)
-	return nil
-}
-
-func CheckRefreshToken(jti string) bool {
-	log.Println("In custom check token id")
-	return refreshTokens[jti] != ""
-}
-
 func LogUserIn(username string, password string) (models.User, string, error) {
 	user, uuid, userErr := FetchUserByUsername(username)
 	log.Println(user, uuid, userErr)
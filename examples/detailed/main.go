@@ -1,7 +1,6 @@
 package main
 
 import (
-	"./db"
 	"./server"
 	"log"
 )
@@ -10,9 +9,6 @@ var host = "localhost"
 var port = "8080"
 
 func main() {
-	// init the DB
-	db.InitDB()
-
 	// start the server
 	serverErr := server.StartServer(host, port)
 	if serverErr != nil {
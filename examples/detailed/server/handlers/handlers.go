@@ -14,6 +14,10 @@ import (
 
 var restrictedRoute jwt.Auth
 
+// in memory store of refresh tokens
+// in the real world, you would implement jwt.TokenStore with calls to your db
+var refreshTokens = jwt.NewMemoryTokenStore(time.Minute)
+
 func InitHandlers() error {
 	newRouteError := jwt.New(&restrictedRoute, jwt.Options{
 		SigningMethodString:   "RS256",
@@ -31,8 +35,7 @@ func InitHandlers() error {
 	restrictedRoute.SetUnauthorizedHandler(MyUnauthorizedHandler)
 	restrictedRoute.SetErrorHandler(myErrorHandler)
 
-	restrictedRoute.SetRevokeTokenFunction(db.DeleteRefreshToken)
-	restrictedRoute.SetCheckTokenIdFunction(db.CheckRefreshToken)
+	restrictedRoute.SetTokenStore(refreshTokens)
 
 	http.Handle("/", alice.New(recoverHandler).ThenFunc(loginHandler))
 	http.Handle("/register", alice.New(recoverHandler).ThenFunc(registerHandler))
@@ -91,14 +94,9 @@ var loginHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request)
 		} else {
 			// no login err
 			// now generate credentials for this user
-			claimsId, idErr := db.StoreRefreshToken()
-			if idErr != nil {
-				http.Error(w, http.StatusText(500), 500)
-			}
-
+			// the refresh token id is generated and stored by IssueNewTokens
 			claims := jwt.ClaimsType{}
 			claims.StandardClaims.Subject = uuid
-			claims.StandardClaims.Id = claimsId
 			claims.CustomClaims = make(map[string]interface{})
 			claims.CustomClaims["Role"] = user.Role
 
@@ -139,14 +137,9 @@ var registerHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reque
 			log.Println("uuid: " + uuid)
 
 			// now generate cookies for this user
-			claimsId, idErr := db.StoreRefreshToken()
-			if idErr != nil {
-				http.Error(w, http.StatusText(500), 500)
-			}
-
+			// the refresh token id is generated and stored by IssueNewTokens
 			claims := jwt.ClaimsType{}
 			claims.StandardClaims.Subject = uuid
-			claims.StandardClaims.Id = claimsId
 			claims.CustomClaims = make(map[string]interface{})
 			claims.CustomClaims["Role"] = role
 
@@ -178,6 +171,10 @@ var deleteUserHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Req
 		http.Error(w, http.StatusText(500), 500)
 	} else {
 		db.DeleteUser(claims.StandardClaims.Subject)
+		// log the user out of every device, not just this one
+		if err := refreshTokens.RevokeTokensBySubject(claims.StandardClaims.Subject); err != nil {
+			log.Println(err)
+		}
 		// remove this user's ability to make requests
 		restrictedRoute.NullifyTokens(&w, r)
 		// use 302 to force browser to do GET request
@@ -0,0 +1,116 @@
+package jwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func defaultForbiddenHandler(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, "Forbidden", 403)
+	return
+}
+
+func (a *Auth) SetForbiddenHandler(handler http.Handler) {
+	a.forbiddenHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handler.ServeHTTP(w, r)
+	}
+}
+func (a *Auth) SetForbiddenHandlerWithError(handler ErrorHandlerFunc) {
+	a.forbiddenHandler = handler
+}
+
+// Scopes splits the space delimited scope claim
+// https://tools.ietf.org/html/rfc8693#section-4.2
+func (c ClaimsType) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope returns true if the scope claim includes the given scope
+func (c ClaimsType) HasScope(scope string) bool {
+	return stringInSlice(scope, c.Scopes())
+}
+
+// RequireScopes returns middleware that only lets requests through if the token has every one of the scopes.
+// It must run after the jwt middleware, e.g. a.Handler(a.RequireScopes("read", "write")(h))
+func (a *Auth) RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return a.require(ErrInsufficientScope, func(claims Claims) bool {
+		for _, scope := range scopes {
+			if !claims.Base().HasScope(scope) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// RequireRole returns middleware that only lets requests through if the "Role" claim is one of the roles
+// (see claimValue)
+func (a *Auth) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return a.require(ErrForbidden, func(claims Claims) bool {
+		role, ok := claimValue(claims, "Role").(string)
+		return ok && stringInSlice(role, roles)
+	})
+}
+
+// RequireClaim returns middleware that only lets requests through if the predicate returns true
+// for the claim with the given key (see claimValue). The value is nil if the claim is not set.
+func (a *Auth) RequireClaim(key string, predicate func(value interface{}) bool) func(http.Handler) http.Handler {
+	return a.require(ErrForbidden, func(claims Claims) bool {
+		return predicate(claimValue(claims, key))
+	})
+}
+
+// claimValue returns the custom claim with the given key or, with a claims factory (see
+// SetClaimsFactory), the field of the typed claims with that json name. Values are as json
+// decodes them, e.g. numbers are float64s, either way.
+func claimValue(claims Claims, key string) interface{} {
+	if value, ok := claims.Base().CustomClaims[key]; ok {
+		return value
+	}
+	if _, ok := claims.(*ClaimsType); ok {
+		return nil
+	}
+
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+	return fields[key]
+}
+
+// require returns middleware that checks the claims that the jwt middleware stored in the request's context
+func (a *Auth) require(forbiddenErr error, check func(claims Claims) bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the jwt middleware passes OPTIONS requests through without checking them
+			if r.Method == "OPTIONS" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			claims, ok := TypedClaimsFromContext(r.Context())
+			if !ok {
+				a.myLog("No claims in request context! Is the jwt middleware running first?")
+				a.setBearerChallenge(w, "", "")
+				a.unauthorizedHandler(w, r, ErrNoClaims)
+				return
+			}
+
+			if !check(claims) {
+				a.myLog("Forbidden attempt! Claims don't allow access")
+				if forbiddenErr == ErrInsufficientScope {
+					a.setBearerChallenge(w, bearerErrorInsufficientScope, forbiddenErr.Error())
+				}
+				a.forbiddenHandler(w, r, forbiddenErr)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/adam-hanna/randomstrings"
+)
+
+// codes are short lived, so that a leaked code is unlikely to still be usable
+// https://tools.ietf.org/html/rfc6749#section-4.1.2
+const authorizationCodeValidTime = time.Minute
+
+// the only PKCE code challenge method that is supported; "plain" gives no protection if the
+// authorization request is intercepted
+// https://tools.ietf.org/html/rfc7636#section-4.2
+const codeChallengeMethodS256 = "S256"
+
+// error codes for authorization error responses, in addition to the token endpoint's
+// https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+const (
+	oauth2ErrorAccessDenied            = "access_denied"
+	oauth2ErrorUnsupportedResponseType = "unsupported_response_type"
+)
+
+// AuthorizationRequest is a client's request for an authorization code, as passed to an AuthorizeFunc
+type AuthorizationRequest struct {
+	ClientId string
+	// RedirectURI is empty if the client didn't send one, and only registered one
+	RedirectURI string
+	// Scope is a space delimited list of the requested scopes
+	Scope string
+	State string
+	// Nonce goes in the id token (see SetUserInfoProvider)
+	Nonce         string
+	CodeChallenge string
+}
+
+// AuthorizeFunc shows the login UI for an authorization request that the AuthorizeHandler has
+// checked. Once the user has logged in and agreed, it returns the claims to issue the tokens with,
+// and the user is redirected back to the client with an authorization code. Until then, it writes
+// its own response, e.g. the login page, and returns nil claims.
+// Return ErrAccessDenied if the user refuses; any other error is an internal server error.
+type AuthorizeFunc func(w http.ResponseWriter, r *http.Request, request AuthorizationRequest) (Claims, error)
+
+// AuthorizationCode is an authorization code that is waiting to be exchanged at the TokenEndpoint
+type AuthorizationCode struct {
+	Code          string
+	ClientId      string
+	RedirectURI   string
+	CodeChallenge string
+	Nonce         string
+	Claims        Claims
+	ExpiresAt     time.Time
+}
+
+// AuthorizationCodeStore keeps authorization codes until they are exchanged.
+// TakeCode removes the code, so that it can only be used once, and returns ErrCodeNotFound
+// if it doesn't exist. Expired codes are rejected by the TokenEndpoint, whether or not the
+// store has removed them.
+type AuthorizationCodeStore interface {
+	StoreCode(code AuthorizationCode) error
+	TakeCode(code string) (AuthorizationCode, error)
+}
+
+// SetAuthorizationCodeStore replaces the in memory code store, e.g. with one that is shared
+// by every instance of the auth server. It's given the Options.Clock if it has a SetClock method.
+func (a *Auth) SetAuthorizationCodeStore(store AuthorizationCodeStore) {
+	a.setStoreClock(store)
+	a.codeStore = store
+}
+
+// MemoryAuthorizationCodeStore is a concurrency safe, in memory AuthorizationCodeStore.
+// Expired codes are removed whenever a new code is stored.
+type MemoryAuthorizationCodeStore struct {
+	clock Clock
+	mu    sync.Mutex
+	codes map[string]AuthorizationCode
+}
+
+// NewMemoryAuthorizationCodeStore constructs a new MemoryAuthorizationCodeStore
+func NewMemoryAuthorizationCodeStore() *MemoryAuthorizationCodeStore {
+	return &MemoryAuthorizationCodeStore{
+		clock: systemClock{},
+		codes: make(map[string]AuthorizationCode),
+	}
+}
+
+func (s *MemoryAuthorizationCodeStore) StoreCode(code AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for c, stored := range s.codes {
+		if !now.Before(stored.ExpiresAt) {
+			delete(s.codes, c)
+		}
+	}
+
+	s.codes[code.Code] = code
+	return nil
+}
+
+func (s *MemoryAuthorizationCodeStore) TakeCode(code string) (AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.codes[code]
+	if !ok {
+		return AuthorizationCode{}, ErrCodeNotFound
+	}
+	delete(s.codes, code)
+	return stored, nil
+}
+
+// SetClock sets the clock that codes expire by. It should be the same as the Auth's Options.Clock.
+func (s *MemoryAuthorizationCodeStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = clock
+}
+
+// redirectURIRegistry holds the redirect uris that each client has registered
+type redirectURIRegistry struct {
+	mu   sync.RWMutex
+	uris map[string][]string
+}
+
+// RegisterRedirectURIs registers the uris that the AuthorizeHandler may redirect the client's
+// users back to. They must be absolute, e.g. "https://app.example.com/callback" or a mobile
+// app's "com.example.app:/callback", and requests must match one of them exactly.
+func (a *Auth) RegisterRedirectURIs(clientId string, redirectURIs ...string) error {
+	for _, redirectURI := range redirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil {
+			return err
+		}
+		// https://tools.ietf.org/html/rfc6749#section-3.1.2
+		if !u.IsAbs() || u.Fragment != "" {
+			return errors.New("Redirect URIs must be absolute, and can't have a fragment")
+		}
+	}
+
+	a.redirectURIs.mu.Lock()
+	defer a.redirectURIs.mu.Unlock()
+
+	a.redirectURIs.uris[clientId] = append(a.redirectURIs.uris[clientId], redirectURIs...)
+	return nil
+}
+
+// redirectURI returns the uri to redirect the client's user to. If the request didn't include one,
+// the client must have registered exactly one.
+func (a *Auth) redirectURI(clientId string, requested string) (string, bool) {
+	a.redirectURIs.mu.RLock()
+	defer a.redirectURIs.mu.RUnlock()
+
+	registered := a.redirectURIs.uris[clientId]
+	if requested == "" {
+		if len(registered) != 1 {
+			return "", false
+		}
+		return registered[0], true
+	}
+	return requested, stringInSlice(requested, registered)
+}
+
+// AuthorizeHandler returns an OAuth2 authorization endpoint for the authorization code grant with
+// PKCE (https://tools.ietf.org/html/rfc7636), for browser and mobile clients that shouldn't handle
+// the user's password. The request is checked against the client's registered redirect uris
+// (see RegisterRedirectURIs), and must carry an S256 code challenge. authorize then shows the
+// login UI. Once it returns the user's claims, the user is redirected back to the client with a
+// single use code, which the client exchanges at the TokenEndpoint within a minute.
+func (a *Auth) AuthorizeHandler(authorize AuthorizeFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		if a.options.VerifyOnlyServer {
+			a.myLog("Server is not authorized to issue new tokens")
+			a.errorHandler(w, r, ErrVerifyOnly)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "The request can't be parsed")
+			return
+		}
+
+		request := AuthorizationRequest{
+			ClientId:      r.Form.Get("client_id"),
+			RedirectURI:   r.Form.Get("redirect_uri"),
+			Scope:         r.Form.Get("scope"),
+			State:         r.Form.Get("state"),
+			Nonce:         r.Form.Get("nonce"),
+			CodeChallenge: r.Form.Get("code_challenge"),
+		}
+
+		// the user mustn't be sent to a uri that the client hasn't registered
+		// https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+		redirectURI, ok := a.redirectURI(request.ClientId, request.RedirectURI)
+		if !ok {
+			a.myLog("Redirect uri is not registered for the client!")
+			writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "Unknown client_id or redirect_uri")
+			return
+		}
+
+		if r.Form.Get("response_type") != "code" {
+			redirectOAuth2Error(w, r, redirectURI, request.State, oauth2ErrorUnsupportedResponseType, "Only the code response type is supported")
+			return
+		}
+		if r.Form.Get("code_challenge_method") != codeChallengeMethodS256 || !validCodeChallenge(request.CodeChallenge) {
+			redirectOAuth2Error(w, r, redirectURI, request.State, oauth2ErrorInvalidRequest, "An S256 code_challenge is required")
+			return
+		}
+
+		claims, err := authorize(w, r, request)
+		if err == ErrAccessDenied {
+			redirectOAuth2Error(w, r, redirectURI, request.State, oauth2ErrorAccessDenied, err.Error())
+			return
+		}
+		if err != nil {
+			a.myLog(err)
+			a.errorHandler(w, r, err)
+			return
+		}
+		if claims == nil {
+			// authorize is showing the login UI
+			return
+		}
+
+		code, err := a.newAuthorizationCode(request, claims)
+		if err != nil {
+			a.myLog(err)
+			a.errorHandler(w, r, err)
+			return
+		}
+
+		a.myLog("Successfully issued an authorization code")
+		redirectOAuth2(w, r, redirectURI, url.Values{"code": {code}, "state": {request.State}})
+	})
+}
+
+// newAuthorizationCode stores the claims under a new code
+func (a *Auth) newAuthorizationCode(request AuthorizationRequest, claims Claims) (string, error) {
+	claims, err := a.copyClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	claims.Base().ClientId = request.ClientId
+
+	code, err := randomstrings.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.codeStore.StoreCode(AuthorizationCode{
+		Code:          code,
+		ClientId:      request.ClientId,
+		RedirectURI:   request.RedirectURI,
+		CodeChallenge: request.CodeChallenge,
+		Nonce:         request.Nonce,
+		Claims:        claims,
+		ExpiresAt:     a.now().Add(authorizationCodeValidTime),
+	})
+	return code, err
+}
+
+// authorizationCodeGrant exchanges an authorization code for tokens, which are issued like
+// IssueNewTokens issues them
+// https://tools.ietf.org/html/rfc6749#section-4.1.3
+func (a *Auth) authorizationCodeGrant(r *http.Request, clientId string) (tokenResponse, error) {
+	codeString := r.PostForm.Get("code")
+	codeVerifier := r.PostForm.Get("code_verifier")
+	if codeString == "" || codeVerifier == "" {
+		return tokenResponse{}, oauth2Error{oauth2ErrorInvalidRequest, "No code or code_verifier"}
+	}
+
+	// the code is used up, even if the exchange fails
+	code, err := a.codeStore.TakeCode(codeString)
+	if err == ErrCodeNotFound {
+		a.myLog("Authorization code is unknown or has already been used!")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	if !a.now().Before(code.ExpiresAt) {
+		a.myLog("Authorization code has expired")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+	if code.ClientId != clientId {
+		a.myLog("Authorization code was issued to another client!")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+	// the redirect uri must match the authorization request's, if it had one
+	if r.PostForm.Get("redirect_uri") != code.RedirectURI {
+		a.myLog("Redirect uri doesn't match the authorization request's!")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+	if !validCodeVerifier(codeVerifier) {
+		a.myLog("Code verifier is not 43 to 128 unreserved characters!")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+	if !verifyCodeChallenge(code.CodeChallenge, codeVerifier) {
+		a.myLog("Code verifier doesn't match the code challenge!")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+
+	authTokenString, refreshTokenString, _, claims, err := a.newTokens(r, code.Claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return a.newUserTokenResponse(r, authTokenString, refreshTokenString, claims, code.Nonce)
+}
+
+// validCodeChallenge checks that the challenge is a base64url encoded sha256 hash
+func validCodeChallenge(challenge string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(b) == sha256.Size
+}
+
+// validCodeVerifier checks that the verifier is 43 to 128 unreserved characters
+// https://tools.ietf.org/html/rfc7636#section-4.1
+func validCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		unreserved := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~'
+		if !unreserved {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyCodeChallenge checks the verifier against the S256 challenge
+// https://tools.ietf.org/html/rfc7636#section-4.6
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// redirectOAuth2Error sends the user back to the client with an error
+func redirectOAuth2Error(w http.ResponseWriter, r *http.Request, redirectURI string, state string, code string, description string) {
+	redirectOAuth2(w, r, redirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {state}})
+}
+
+// redirectOAuth2 sends the user back to the client, with the params added to the redirect uri's query
+func redirectOAuth2(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	// the uri has been checked against the registered uris, which parse
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
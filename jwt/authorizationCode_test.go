@@ -0,0 +1,147 @@
+package jwt_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+const (
+	redirectURI  = "https://app.example.com/callback"
+	codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// authorize asks the AuthorizeHandler for a code for bob, with an S256 challenge of codeVerifier
+// and the requested scope, and returns the uri that the user is redirected to
+func authorize(t *testing.T, h *jwttest.Harness, query url.Values) *url.URL {
+	t.Helper()
+
+	hash := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"app"},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(hash[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for key, values := range query {
+		params[key] = values
+	}
+
+	handler := h.Auth.AuthorizeHandler(func(w http.ResponseWriter, r *http.Request, request jwt.AuthorizationRequest) (jwt.Claims, error) {
+		claims := bob
+		claims.Scope = request.Scope
+		return &claims, nil
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?"+params.Encode(), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("code = %d, body = %q", w.Code, w.Body)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func newAuthorizationCodeHarness(t *testing.T) *jwttest.Harness {
+	h := newOAuth2Harness(t, jwt.Options{AuthorizationHeader: true})
+	if err := h.Auth.RegisterRedirectURIs("app", redirectURI); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	h := newAuthorizationCodeHarness(t)
+
+	location := authorize(t, h, nil)
+	code := location.Query().Get("code")
+	if location.Host != "app.example.com" || location.Query().Get("state") != "xyz" || code == "" {
+		t.Fatalf("redirected to %v", location)
+	}
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {codeVerifier}}
+	status, response := grant(t, h, "app", exchange)
+	if status != 200 || response.AccessToken == "" || response.RefreshToken == "" {
+		t.Fatalf("code = %d, response = %+v", status, response)
+	}
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", jwttest.Tokens{AuthToken: response.AccessToken})); w.Code != 200 {
+		t.Errorf("access token code = %d", w.Code)
+	}
+
+	// codes can only be used once
+	if status, response := grant(t, h, "app", exchange); status != 400 || response.Error != "invalid_grant" {
+		t.Errorf("reused code: code = %d, response = %+v", status, response)
+	}
+}
+
+func TestAuthorizationCodeGrantIsChecked(t *testing.T) {
+	h := newAuthorizationCodeHarness(t)
+
+	for name, test := range map[string]struct {
+		clientId string
+		exchange url.Values
+		wait     time.Duration
+	}{
+		"wrong code_verifier": {"app", url.Values{"redirect_uri": {redirectURI}, "code_verifier": {"wrong"}}, 0},
+		"wrong redirect_uri":  {"app", url.Values{"redirect_uri": {"https://evil.example.com/"}, "code_verifier": {codeVerifier}}, 0},
+		"another client":      {"other", url.Values{"redirect_uri": {redirectURI}, "code_verifier": {codeVerifier}}, 0},
+		"expired code":        {"app", url.Values{"redirect_uri": {redirectURI}, "code_verifier": {codeVerifier}}, 2 * time.Minute},
+	} {
+		code := authorize(t, h, nil).Query().Get("code")
+		h.Clock.Advance(test.wait)
+
+		test.exchange.Set("grant_type", "authorization_code")
+		test.exchange.Set("code", code)
+		if status, response := grant(t, h, test.clientId, test.exchange); status != 400 || response.Error != "invalid_grant" {
+			t.Errorf("%s: code = %d, response = %+v", name, status, response)
+		}
+	}
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	h := newAuthorizationCodeHarness(t)
+
+	location := authorize(t, h, url.Values{"code_challenge_method": {"plain"}})
+	if location.Query().Get("error") != "invalid_request" || location.Query().Get("code") != "" {
+		t.Errorf("redirected to %v", location)
+	}
+}
+
+func TestCodeVerifierFormat(t *testing.T) {
+	h := newAuthorizationCodeHarness(t)
+
+	// the code challenges match, so only the format of the verifiers is checked
+	for verifier, valid := range map[string]bool{
+		strings.Repeat("a", 43):                true,
+		strings.Repeat("a", 128):               true,
+		"az-AZ.09_~" + strings.Repeat("a", 33): true,
+		strings.Repeat("a", 42):                false,
+		strings.Repeat("a", 129):               false,
+		"a+/=" + strings.Repeat("a", 39):       false,
+		"a b" + strings.Repeat("a", 40):        false,
+	} {
+		hash := sha256.Sum256([]byte(verifier))
+		code := authorize(t, h, url.Values{"code_challenge": {base64.RawURLEncoding.EncodeToString(hash[:])}}).Query().Get("code")
+
+		exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}}
+		status, response := grant(t, h, "app", exchange)
+		if valid && status != 200 {
+			t.Errorf("%q: code = %d, response = %+v", verifier, status, response)
+		} else if !valid && (status != 400 || response.Error != "invalid_grant") {
+			t.Errorf("%q: code = %d, response = %+v", verifier, status, response)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package jwt_test
+
+import (
+	"testing"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// typedClaims are custom claims, decoded by a claims factory
+type typedClaims struct {
+	jwt.ClaimsType
+	Role     string
+	Verified bool
+}
+
+func newTypedClaimsHarness(t *testing.T) *jwttest.Harness {
+	h := jwttest.New(t)
+	h.Auth.SetClaimsFactory(func() jwt.Claims {
+		return &typedClaims{}
+	})
+	return h
+}
+
+func isTrue(value interface{}) bool {
+	verified, ok := value.(bool)
+	return ok && verified
+}
+
+func TestRequireRoleAndClaim(t *testing.T) {
+	mapClaims := bob
+	mapClaims.CustomClaims = map[string]interface{}{"Role": "admin", "Verified": true}
+
+	for name, test := range map[string]struct {
+		h      *jwttest.Harness
+		claims jwt.Claims
+	}{
+		"custom claims": {jwttest.New(t), &mapClaims},
+		"typed claims":  {newTypedClaimsHarness(t), &typedClaims{ClaimsType: bob, Role: "admin", Verified: true}},
+	} {
+		h := test.h
+		tokens := h.IssueWithClaims(test.claims)
+
+		if w := h.Serve(h.Auth.RequireRole("user", "admin")(okHandler), h.NewRequest("GET", "/", tokens)); w.Code != 200 {
+			t.Errorf("%s: admin role code = %d", name, w.Code)
+		}
+		if w := h.Serve(h.Auth.RequireRole("owner")(okHandler), h.NewRequest("GET", "/", tokens)); w.Code != 403 {
+			t.Errorf("%s: owner role code = %d, want 403", name, w.Code)
+		}
+		if w := h.Serve(h.Auth.RequireClaim("Verified", isTrue)(okHandler), h.NewRequest("GET", "/", tokens)); w.Code != 200 {
+			t.Errorf("%s: verified claim code = %d", name, w.Code)
+		}
+		if w := h.Serve(h.Auth.RequireClaim("Missing", isTrue)(okHandler), h.NewRequest("GET", "/", tokens)); w.Code != 403 {
+			t.Errorf("%s: missing claim code = %d, want 403", name, w.Code)
+		}
+	}
+}
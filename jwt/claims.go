@@ -0,0 +1,56 @@
+package jwt
+
+import (
+	"encoding/json"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// Claims is implemented by *ClaimsType and by pointers to any struct that embeds ClaimsType.
+// Embed ClaimsType in your own struct to get typed custom claims, e.g.
+//
+//	type MyClaims struct {
+//		jwt.ClaimsType
+//		Role  string
+//		Perms []int
+//	}
+//
+// and register it with SetClaimsFactory.
+type Claims interface {
+	jwtGo.Claims
+	// Base returns the embedded ClaimsType, which holds the claims this package manages
+	Base() *ClaimsType
+}
+
+// Base returns the claims themselves, so that structs embedding ClaimsType satisfy Claims
+func (c *ClaimsType) Base() *ClaimsType {
+	return c
+}
+
+// ClaimsFactory returns a new, empty claims value (e.g. &MyClaims{}) that tokens are decoded into
+type ClaimsFactory func() Claims
+
+func defaultClaimsFactory() Claims {
+	return &ClaimsType{}
+}
+
+// SetClaimsFactory registers the claims type that every token is decoded into.
+// Use TypedClaimsFromContext to get the claims back out of a request.
+func (a *Auth) SetClaimsFactory(factory ClaimsFactory) {
+	a.newClaims = factory
+}
+
+// copyClaims returns a deep copy of the claims, as the type made by the claims factory
+func (a *Auth) copyClaims(claims Claims) (Claims, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	c := a.newClaims()
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
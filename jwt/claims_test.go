@@ -0,0 +1,75 @@
+package jwt_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// accountClaims are typed claims with fields that aren't strings
+type accountClaims struct {
+	jwt.ClaimsType
+	Perms  []int              `json:"perms"`
+	Limits map[string]float64 `json:"limits"`
+	Admin  bool               `json:"admin"`
+	// more than a float64 can hold exactly
+	Quota int64 `json:"quota"`
+	Ratio float64
+}
+
+func TestTypedClaimsRoundTrip(t *testing.T) {
+	h := jwttest.New(t)
+	h.Auth.SetClaimsFactory(func() jwt.Claims {
+		return &accountClaims{}
+	})
+
+	issued := accountClaims{
+		ClaimsType: bob,
+		Perms:      []int{1, 2, 40},
+		Limits:     map[string]float64{"requests": 100, "burst": 2.5},
+		Admin:      true,
+		Quota:      1<<53 + 1,
+		Ratio:      0.75,
+	}
+	tokens := h.IssueWithClaims(&issued)
+
+	var got *accountClaims
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := jwt.TypedClaimsFromContext(r.Context())
+		if !ok {
+			t.Fatal("no claims in the context")
+		}
+		if got, ok = claims.(*accountClaims); !ok {
+			t.Fatalf("claims are a %T", claims)
+		}
+		if base, ok := jwt.ClaimsFromContext(r.Context()); !ok || base.Subject != "bob" {
+			t.Errorf("base claims = %+v", base)
+		}
+	})
+
+	check := func(when string) {
+		t.Helper()
+		if got == nil {
+			t.Fatalf("%s: the handler wasn't called", when)
+		}
+		if got.Subject != "bob" || !reflect.DeepEqual(got.Perms, issued.Perms) || !reflect.DeepEqual(got.Limits, issued.Limits) ||
+			got.Admin != issued.Admin || got.Quota != issued.Quota || got.Ratio != issued.Ratio {
+			t.Errorf("%s: claims = %+v, want %+v", when, got, issued)
+		}
+		got = nil
+	}
+
+	if w := h.Serve(handler, h.NewRequest("GET", "/", tokens)); w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	check("issued")
+
+	// the claims are carried over to the refreshed auth token
+	if w := h.Serve(handler, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens))); w.Code != 200 {
+		t.Fatalf("refresh code = %d", w.Code)
+	}
+	check("refreshed")
+}
@@ -0,0 +1,455 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultClientRefreshBefore = 10 * time.Second
+
+// Client is an http.RoundTripper for Go programs that call servers protected by this middleware.
+// It stores the tokens and csrf secret from every response, and sends them with every request.
+// The zero value uses cookies (without a cookie jar; don't set one on the http.Client) and
+// http.DefaultTransport. A Client is safe for concurrent use.
+//
+//	c := &jwt.Client{}
+//	httpClient := c.HTTPClient()
+//	resp, err := httpClient.PostForm("https://example.com/login", credentials)
+//	...
+//	resp, err = httpClient.Get("https://example.com/restricted")
+//
+// The tokens are only sent to the origin that issued them (e.g. "https://example.com"), the
+// RefreshURL's origin and any Origins, or with cookies, to the auth cookie's Domain. Requests to
+// any other host, e.g. after a redirect, are sent without them.
+type Client struct {
+	// Base makes the requests. http.DefaultTransport is used if nil.
+	Base http.RoundTripper
+
+	// AuthorizationHeader, BearerTokens and CookieOptions need to match the server's options
+	AuthorizationHeader bool
+	BearerTokens        bool
+	CookieOptions       CookieOptions
+
+	// RefreshURL is the server's RefreshHandler. If set, the refresh token is only sent there,
+	// and the tokens are refreshed shortly before the auth token expires (see RefreshEndpoint).
+	// Otherwise, the refresh token is sent with every request and the server refreshes the tokens.
+	RefreshURL string
+
+	// RefreshBefore is how long before the auth token expires to refresh it (10 seconds by default)
+	RefreshBefore time.Duration
+
+	// Origins are other origins, e.g. "https://api.example.com", that the tokens are sent to
+	Origins []string
+
+	mu            sync.Mutex
+	authToken     string
+	refreshToken  string
+	csrfSecret    string
+	authExpiry    time.Time
+	refreshExpiry time.Time
+	// the origin that issued the tokens, and the auth cookie's Domain, if it has one
+	origin string
+	domain string
+
+	// only one request at a time refreshes the tokens, so that a rotated refresh token isn't reused
+	refreshMu sync.Mutex
+}
+
+// HTTPClient returns an http.Client that uses c as its transport
+func (c *Client) HTTPClient() *http.Client {
+	return &http.Client{Transport: c}
+}
+
+// SetTokens sets the tokens and csrf secret, e.g. if they were issued outside of the client.
+// serverURL is the server that issued them, e.g. "https://example.com"; they're only sent there.
+func (c *Client) SetTokens(serverURL string, authToken string, refreshToken string, csrfSecret string) error {
+	origin := ""
+	if serverURL != "" {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return err
+		}
+		origin = urlOrigin(u)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authToken = authToken
+	c.refreshToken = refreshToken
+	c.csrfSecret = csrfSecret
+	c.authExpiry = time.Time{}
+	c.refreshExpiry = time.Time{}
+	c.origin = origin
+	c.domain = ""
+	return nil
+}
+
+// ClearTokens forgets the tokens and csrf secret, e.g. when logging out
+func (c *Client) ClearTokens() {
+	c.SetTokens("", "", "", "")
+}
+
+// RoundTrip implements http.RoundTripper
+func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !c.sendsTokensTo(req.URL) || !c.needsRefresh() {
+		return c.roundTrip(req, c.RefreshURL == "")
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	// another request may have refreshed the tokens while we waited
+	if !c.needsRefresh() {
+		return c.roundTrip(req, c.RefreshURL == "")
+	}
+
+	if c.RefreshURL == "" {
+		// the server refreshes the tokens when it sees an expired auth token, so this request
+		// refreshes them. The others wait for it.
+		return c.roundTrip(req, true)
+	}
+
+	if err := c.refresh(req.Context()); err != nil {
+		return nil, err
+	}
+	return c.roundTrip(req, false)
+}
+
+func (c *Client) roundTrip(req *http.Request, sendRefreshToken bool) (*http.Response, error) {
+	// a RoundTripper mustn't modify the caller's request
+	req = req.Clone(req.Context())
+	if err := c.addTokens(req, sendRefreshToken); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.updateTokens(req, resp)
+	return resp, nil
+}
+
+// refresh calls the RefreshURL
+func (c *Client) refresh(ctx context.Context) error {
+	req, err := http.NewRequest("POST", c.RefreshURL, nil)
+	if err != nil {
+		return err
+	}
+	if c.BearerTokens {
+		// the refresh token is sent as a form value
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.roundTrip(req.WithContext(ctx), true)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		// the refresh token isn't any good; we need to log in again
+		c.ClearTokens()
+	}
+	return nil
+}
+
+// needsRefresh is true if the auth token expires soon, and the refresh token hasn't expired
+func (c *Client) needsRefresh() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.refreshToken == "" || c.authExpiry.IsZero() {
+		return false
+	}
+	if !c.refreshExpiry.IsZero() && !now.Before(c.refreshExpiry) {
+		return false
+	}
+
+	refreshBefore := c.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = defaultClientRefreshBefore
+	}
+	return !now.Add(refreshBefore).Before(c.authExpiry)
+}
+
+// sendsTokensTo reports whether the tokens may be sent to the url
+func (c *Client) sendsTokensTo(u *url.URL) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.trusts(u)
+}
+
+// trusts reports whether the url is on the origin that issued the tokens, the RefreshURL's origin or
+// one of Origins, or, with cookies, in the auth cookie's Domain. c.mu must be held.
+func (c *Client) trusts(u *url.URL) bool {
+	if c.origin == "" {
+		return false
+	}
+
+	origin := urlOrigin(u)
+	if origin == c.origin {
+		return true
+	}
+	if c.RefreshURL != "" {
+		if refreshURL, err := url.Parse(c.RefreshURL); err == nil && urlOrigin(refreshURL) == origin {
+			return true
+		}
+	}
+	for _, o := range c.Origins {
+		if strings.ToLower(strings.TrimSuffix(o, "/")) == origin {
+			return true
+		}
+	}
+
+	// like a browser, send domain cookies to the domain and its subdomains, but never from https to http
+	return c.domain != "" && strings.HasPrefix(c.origin, strings.ToLower(u.Scheme)+"://") &&
+		domainMatch(strings.ToLower(u.Hostname()), c.domain)
+}
+
+func (c *Client) addTokens(req *http.Request, sendRefreshToken bool) error {
+	c.mu.Lock()
+	if !c.trusts(req.URL) {
+		c.mu.Unlock()
+		return nil
+	}
+	authToken, csrfSecret := c.authToken, c.csrfSecret
+	refreshToken := ""
+	if sendRefreshToken {
+		refreshToken = c.refreshToken
+	}
+	c.mu.Unlock()
+
+	if csrfSecret != "" && !c.AuthorizationHeader {
+		req.Header.Set("X-CSRF-Token", csrfSecret)
+	}
+
+	if c.AuthorizationHeader {
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		if refreshToken != "" {
+			req.Header.Set(refreshTokenHeader, refreshToken)
+		}
+		return nil
+	}
+
+	if c.BearerTokens {
+		return addBearerTokens(req, authToken, refreshToken)
+	}
+
+	if authToken != "" {
+		req.AddCookie(&http.Cookie{Name: c.authCookieName(), Value: authToken})
+	}
+	if refreshToken != "" {
+		req.AddCookie(&http.Cookie{Name: c.refreshCookieName(), Value: refreshToken})
+	}
+	return nil
+}
+
+// addBearerTokens adds the tokens where the middleware reads them in BearerTokens mode: to the
+// request's json or form body, or, if it has neither, to its url's query
+func addBearerTokens(req *http.Request, authToken string, refreshToken string) error {
+	values := make(map[string]string)
+	if authToken != "" {
+		values["Auth_Token"] = authToken
+	}
+	if refreshToken != "" {
+		values["Refresh_Token"] = refreshToken
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	switch req.Header.Get("Content-Type") {
+	case "application/json":
+		content, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		body := make(map[string]json.RawMessage)
+		if len(bytes.TrimSpace(content)) > 0 {
+			if err := json.Unmarshal(content, &body); err != nil {
+				return err
+			}
+		}
+		for key, value := range values {
+			body[key], _ = json.Marshal(value)
+		}
+		content, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		setBody(req, content)
+
+	case "application/x-www-form-urlencoded":
+		content, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		form, err := url.ParseQuery(string(content))
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			form.Set(key, value)
+		}
+		setBody(req, []byte(form.Encode()))
+
+	default:
+		query := req.URL.Query()
+		for key, value := range values {
+			query.Set(key, value)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	return nil
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return ioutil.ReadAll(req.Body)
+}
+
+func setBody(req *http.Request, content []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(content))
+	req.ContentLength = int64(len(content))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+}
+
+// updateTokens stores any tokens, csrf secret and expiry times in the response. Once the client
+// has tokens, only the servers that they're sent to can replace them.
+func (c *Client) updateTokens(req *http.Request, resp *http.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.origin != "" && !c.trusts(req.URL) {
+		return
+	}
+
+	var authToken, refreshToken, domain string
+	var hasAuthToken, hasRefreshToken bool
+	if c.AuthorizationHeader {
+		authToken, hasAuthToken = headerValue(resp, authTokenHeader)
+		refreshToken, hasRefreshToken = headerValue(resp, refreshTokenHeader)
+	} else if c.BearerTokens {
+		authToken, hasAuthToken = headerValue(resp, "Auth_Token")
+		refreshToken, hasRefreshToken = headerValue(resp, "Refresh_Token")
+	} else {
+		for _, cookie := range resp.Cookies() {
+			// nullified cookies have an empty value
+			switch cookie.Name {
+			case c.authCookieName():
+				authToken, hasAuthToken = cookie.Value, true
+				domain = strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+			case c.refreshCookieName():
+				refreshToken, hasRefreshToken = cookie.Value, true
+			}
+		}
+	}
+
+	if c.origin == "" {
+		if authToken == "" {
+			// there's nothing to keep yet
+			return
+		}
+		c.origin = urlOrigin(req.URL)
+	}
+	if hasAuthToken {
+		c.authToken = authToken
+		// a cookie domain only counts if the server was allowed to set it
+		c.domain = ""
+		if domain != "" && domainMatch(strings.ToLower(req.URL.Hostname()), domain) {
+			c.domain = domain
+		}
+	}
+	if hasRefreshToken {
+		c.refreshToken = refreshToken
+	}
+
+	if value, ok := headerValue(resp, "X-CSRF-Token"); ok {
+		c.csrfSecret = value
+	}
+	if exp, ok := expiryHeader(resp, "Auth-Expiry"); ok {
+		c.authExpiry = exp
+	}
+	if exp, ok := expiryHeader(resp, "Refresh-Expiry"); ok {
+		c.refreshExpiry = exp
+	}
+}
+
+func (c *Client) authCookieName() string {
+	name := c.CookieOptions.AuthTokenName
+	if name == "" {
+		name = defaultAuthTokenCookieName
+	}
+	return c.CookieOptions.Prefix + name
+}
+
+func (c *Client) refreshCookieName() string {
+	name := c.CookieOptions.RefreshTokenName
+	if name == "" {
+		name = defaultRefreshTokenCookieName
+	}
+	return c.CookieOptions.Prefix + name
+}
+
+func (c *Client) base() http.RoundTripper {
+	if c.Base != nil {
+		return c.Base
+	}
+	return http.DefaultTransport
+}
+
+// urlOrigin returns the url's scheme and host, e.g. "https://example.com"
+func urlOrigin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// domainMatch reports whether the host is the domain or one of its subdomains
+func domainMatch(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// headerValue is like resp.Header.Get, but also reports whether the header is present
+// (e.g. NullifyTokens sets an empty X-CSRF-Token)
+func headerValue(resp *http.Response, header string) (string, bool) {
+	values, ok := resp.Header[http.CanonicalHeaderKey(header)]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// expiryHeader parses an expiry header, which is in unix time
+func expiryHeader(resp *http.Response, header string) (time.Time, bool) {
+	value := resp.Header.Get(header)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
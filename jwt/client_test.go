@@ -0,0 +1,234 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// newClientServer serves the harness' Auth: "/login" issues tokens, "/refresh" is the RefreshHandler,
+// and everything else is restricted and served by handler
+func newClientServer(t *testing.T, h *jwttest.Harness, handler http.Handler) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Auth.IssueNewTokens(w, bob); err != nil {
+			t.Error(err)
+		}
+	}))
+	mux.Handle("/refresh", h.Auth.RefreshHandler())
+	mux.Handle("/", h.Auth.Handler(handler))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// recordingServer records whether requests to it carried any credentials
+type recordingServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	credentials []string
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, header := range []string{"Cookie", "Authorization", "Refresh-Token", "X-CSRF-Token"} {
+			if r.Header.Get(header) != "" {
+				s.credentials = append(s.credentials, header)
+			}
+		}
+		if r.URL.RawQuery != "" {
+			s.credentials = append(s.credentials, "query "+r.URL.RawQuery)
+		}
+		// a malicious server trying to replace the client's tokens
+		w.Header().Set("Auth-Token", "stolen")
+		http.SetCookie(w, &http.Cookie{Name: "AuthToken", Value: "stolen"})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.credentials
+}
+
+func TestClientOnlySendsTokensToTheirOrigin(t *testing.T) {
+	for name, options := range transportModes {
+		t.Run(name, func(t *testing.T) {
+			h := jwttest.New(t, options)
+			other := newRecordingServer(t)
+			server := newClientServer(t, h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/redirect" {
+					http.Redirect(w, r, other.URL+"/landing", http.StatusFound)
+				}
+			}))
+
+			c := &jwt.Client{AuthorizationHeader: options.AuthorizationHeader, BearerTokens: options.BearerTokens}
+			httpClient := c.HTTPClient()
+			if _, err := httpClient.Get(server.URL + "/login"); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := httpClient.Get(server.URL + "/redirect")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.Request.URL.Host != other.Listener.Addr().String() {
+				t.Fatalf("the redirect wasn't followed: %v", resp.Request.URL)
+			}
+			if _, err := httpClient.Get(other.URL + "/direct"); err != nil {
+				t.Fatal(err)
+			}
+			if received := other.received(); len(received) != 0 {
+				t.Errorf("credentials were sent to another host: %v", received)
+			}
+
+			// the other host couldn't replace the tokens, either
+			resp, err = httpClient.Get(server.URL + "/restricted")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("code = %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestClientOrigins(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{AuthorizationHeader: true})
+	other := newRecordingServer(t)
+	server := newClientServer(t, h, okHandler)
+
+	c := &jwt.Client{AuthorizationHeader: true, Origins: []string{other.URL}}
+	httpClient := c.HTTPClient()
+	if _, err := httpClient.Get(server.URL + "/login"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := httpClient.Get(other.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a RefreshURL, the refresh token is sent along with the auth token
+	if received := other.received(); strings.Join(received, ",") != "Authorization,Refresh-Token" {
+		t.Errorf("credentials sent to a trusted origin = %v", received)
+	}
+}
+
+func TestClientSetTokens(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{AuthorizationHeader: true})
+	tokens := h.Issue(bob)
+	other := newRecordingServer(t)
+	server := newClientServer(t, h, okHandler)
+
+	c := &jwt.Client{AuthorizationHeader: true}
+	if err := c.SetTokens(server.URL, tokens.AuthToken, tokens.RefreshToken, tokens.CsrfSecret); err != nil {
+		t.Fatal(err)
+	}
+	httpClient := c.HTTPClient()
+
+	resp, err := httpClient.Get(server.URL + "/restricted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("code = %d", resp.StatusCode)
+	}
+
+	if _, err := httpClient.Get(other.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	if received := other.received(); len(received) != 0 {
+		t.Errorf("credentials were sent to another host: %v", received)
+	}
+}
+
+func TestClientBearerTokens(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{BearerTokens: true})
+	server := newClientServer(t, h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request's own values are kept
+		if r.Header.Get("Content-Type") == "application/json" {
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			w.Write([]byte(body["name"]))
+		} else {
+			r.ParseForm()
+			w.Write([]byte(r.Form.Get("name")))
+		}
+	}))
+
+	c := &jwt.Client{BearerTokens: true}
+	httpClient := c.HTTPClient()
+	if _, err := httpClient.Get(server.URL + "/login"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, send := range map[string]func() (*http.Response, error){
+		"query": func() (*http.Response, error) {
+			return httpClient.Get(server.URL + "/restricted?name=bob")
+		},
+		"form": func() (*http.Response, error) {
+			return httpClient.Post(server.URL+"/restricted", "application/x-www-form-urlencoded", strings.NewReader("name=bob"))
+		},
+		"json": func() (*http.Response, error) {
+			return httpClient.Post(server.URL+"/restricted", "application/json", strings.NewReader(`{"name": "bob"}`))
+		},
+	} {
+		resp, err := send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != 200 || string(body) != "bob" {
+			t.Errorf("%s: code = %d, body = %q", name, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestClientBearerTokensRefreshURL(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{BearerTokens: true, RefreshEndpoint: true, RotateRefreshTokens: true})
+	server := newClientServer(t, h, okHandler)
+
+	// the auth token always expires soon, so every request refreshes it first
+	c := &jwt.Client{BearerTokens: true, RefreshURL: server.URL + "/refresh", RefreshBefore: time.Hour}
+	httpClient := c.HTTPClient()
+	if _, err := httpClient.Get(server.URL + "/login"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := h.Store.ListTokensBySubject("bob")
+
+	resp, err := httpClient.Get(server.URL + "/restricted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("code = %d", resp.StatusCode)
+	}
+
+	after, _ := h.Store.ListTokensBySubject("bob")
+	if len(before) != 1 || len(after) != 1 || before[0].Id == after[0].Id {
+		t.Errorf("the refresh token wasn't rotated: %+v, %+v", before, after)
+	}
+}
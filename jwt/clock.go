@@ -0,0 +1,34 @@
+package jwt
+
+import (
+	"time"
+)
+
+// Clock tells the time. Set Options.Clock to control the time that tokens are issued, refreshed
+// and validated at, e.g. in tests (see jwttest.FakeClock). The system clock is used by default.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (a *Auth) now() time.Time {
+	return a.options.Clock.Now()
+}
+
+// clockSetter is implemented by stores whose entries expire, e.g. MemoryTokenStore
+type clockSetter interface {
+	SetClock(clock Clock)
+}
+
+// setStoreClock gives the store the Options.Clock, so that its entries expire by the same clock
+// that tokens do
+func (a *Auth) setStoreClock(store interface{}) {
+	if s, ok := store.(clockSetter); ok && a.options.Clock != nil {
+		s.SetClock(a.options.Clock)
+	}
+}
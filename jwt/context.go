@@ -0,0 +1,38 @@
+package jwt
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const claimsContextKey contextKey = 0
+
+func newContextWithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the verified claims that the middleware stored in the request's context.
+// ok is false if the request was not processed by the middleware (e.g. an OPTIONS request).
+func ClaimsFromContext(ctx context.Context) (claims ClaimsType, ok bool) {
+	c, ok := TypedClaimsFromContext(ctx)
+	if !ok {
+		return ClaimsType{}, false
+	}
+
+	return *c.Base(), true
+}
+
+// TypedClaimsFromContext is like ClaimsFromContext, but returns the claims as the type made by
+// the claims factory (see SetClaimsFactory), e.g.
+//
+//	c, ok := jwt.TypedClaimsFromContext(r.Context())
+//	myClaims := c.(*MyClaims)
+func TypedClaimsFromContext(ctx context.Context) (claims Claims, ok bool) {
+	c, ok := ctx.Value(claimsContextKey).(Claims)
+	if !ok || c == nil {
+		return nil, false
+	}
+
+	return c, true
+}
@@ -0,0 +1,119 @@
+package jwt
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// cookie name prefixes that browsers enforce extra rules for
+// https://tools.ietf.org/html/draft-ietf-httpbis-rfc6265bis-02#section-4.1.3
+const (
+	// __Secure- cookies must be Secure
+	CookiePrefixSecure = "__Secure-"
+	// __Host- cookies must be Secure, have a Path of "/" and no Domain
+	CookiePrefixHost = "__Host-"
+)
+
+const (
+	defaultAuthTokenCookieName    = "AuthToken"
+	defaultRefreshTokenCookieName = "RefreshToken"
+)
+
+// CookieOptions configures the auth and refresh token cookies. It is ignored when
+// BearerTokens or AuthorizationHeader is set.
+type CookieOptions struct {
+	AuthTokenName    string        // defaults to "AuthToken"
+	RefreshTokenName string        // defaults to "RefreshToken"
+	SameSite         http.SameSite // no SameSite attribute is set by default
+	Domain           string
+	Path             string
+	// RefreshTokenPath limits the refresh cookie to a path (e.g. your refresh endpoint).
+	// It defaults to Path.
+	RefreshTokenPath string
+	// Prefix is prepended to both cookie names, either CookiePrefixSecure or CookiePrefixHost
+	Prefix string
+}
+
+// checkCookieOptions sets the default cookie options and checks that the prefix rules can be met
+func checkCookieOptions(o *Options) error {
+	c := &o.CookieOptions
+	if c.AuthTokenName == "" {
+		c.AuthTokenName = defaultAuthTokenCookieName
+	}
+	if c.RefreshTokenName == "" {
+		c.RefreshTokenName = defaultRefreshTokenCookieName
+	}
+	if c.RefreshTokenPath == "" {
+		c.RefreshTokenPath = c.Path
+	}
+	if c.AuthTokenName == c.RefreshTokenName {
+		return errors.New("The auth and refresh token cookies need different names")
+	}
+
+	switch c.Prefix {
+	case "":
+	case CookiePrefixSecure:
+		if o.IsDevEnv {
+			return errors.New("__Secure- cookies can't be used in dev mode, because they must be Secure")
+		}
+	case CookiePrefixHost:
+		if o.IsDevEnv {
+			return errors.New("__Host- cookies can't be used in dev mode, because they must be Secure")
+		}
+		if c.Domain != "" {
+			return errors.New("__Host- cookies can't have a Domain")
+		}
+		if (c.Path != "" && c.Path != "/") || (c.RefreshTokenPath != "" && c.RefreshTokenPath != "/") {
+			return errors.New("__Host- cookies must have a Path of \"/\"")
+		}
+		c.Path = "/"
+		c.RefreshTokenPath = "/"
+	default:
+		return errors.New("Cookie prefix not recognized!")
+	}
+
+	return nil
+}
+
+func (a *Auth) authCookieName() string {
+	return a.options.CookieOptions.Prefix + a.options.CookieOptions.AuthTokenName
+}
+
+func (a *Auth) refreshCookieName() string {
+	return a.options.CookieOptions.Prefix + a.options.CookieOptions.RefreshTokenName
+}
+
+// authCookie builds the auth token cookie. Nullifying the cookie requires the same name,
+// domain and path that it was set with, so always build it here.
+func (a *Auth) authCookie(value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     a.authCookieName(),
+		Value:    value,
+		Expires:  expires,
+		Domain:   a.options.CookieOptions.Domain,
+		Path:     a.options.CookieOptions.Path,
+		SameSite: a.options.CookieOptions.SameSite,
+		HttpOnly: true,
+		Secure:   !a.options.IsDevEnv,
+	}
+}
+
+// refreshCookie builds the refresh token cookie
+func (a *Auth) refreshCookie(value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     a.refreshCookieName(),
+		Value:    value,
+		Expires:  expires,
+		Domain:   a.options.CookieOptions.Domain,
+		Path:     a.options.CookieOptions.RefreshTokenPath,
+		SameSite: a.options.CookieOptions.SameSite,
+		HttpOnly: true,
+		Secure:   !a.options.IsDevEnv,
+	}
+}
+
+// refreshCookieRestricted is true if the refresh cookie isn't sent with every request
+func (a *Auth) refreshCookieRestricted() bool {
+	return a.options.CookieOptions.RefreshTokenPath != a.options.CookieOptions.Path
+}
@@ -0,0 +1,74 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEd25519 implements the EdDSA signing method with Ed25519 keys
+// (https://tools.ietf.org/html/rfc8037), which jwt-go doesn't have. It is registered with jwt-go
+// as "EdDSA". Tokens are signed with an ed25519.PrivateKey, or any crypto.Signer with an
+// ed25519.PublicKey, and verified with an ed25519.PublicKey.
+type SigningMethodEd25519 struct{}
+
+var (
+	SigningMethodEdDSA *SigningMethodEd25519
+
+	ErrEd25519Verification = errors.New("crypto/ed25519: verification error")
+)
+
+func init() {
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+	jwtGo.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwtGo.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+// Verify implements jwtGo.SigningMethod. The key must be an ed25519.PublicKey.
+func (m *SigningMethodEd25519) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwtGo.ErrInvalidKeyType
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return jwtGo.ErrInvalidKey
+	}
+
+	sig, err := jwtGo.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return ErrEd25519Verification
+	}
+	return nil
+}
+
+// Sign implements jwtGo.SigningMethod. The key must be a crypto.Signer with an ed25519.PublicKey,
+// e.g. an ed25519.PrivateKey.
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwtGo.ErrInvalidKeyType
+	}
+	if _, ok := signer.Public().(ed25519.PublicKey); !ok {
+		return "", jwtGo.ErrInvalidKeyType
+	}
+
+	// Ed25519 signs the message itself, not a digest
+	sig, err := signer.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	if err != nil {
+		return "", err
+	}
+
+	return jwtGo.EncodeSegment(sig), nil
+}
@@ -0,0 +1,100 @@
+package jwt
+
+import (
+	"errors"
+	"net/http"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// Errors returned by Process (and passed to the error and unauthorized handlers).
+// Any other error that Process returns is an internal server error.
+var (
+	ErrNoAuthToken          = errors.New("No auth token in request")
+	ErrNoRefreshToken       = errors.New("No refresh token in request")
+	ErrNoCsrfToken          = errors.New("No CSRF token in request")
+	ErrCsrfMismatch         = errors.New("CSRF token doesn't match jwt")
+	ErrMalformedToken       = errors.New("Token is malformed")
+	ErrBadSignature         = errors.New("Token signature is invalid")
+	ErrInvalidSigningMethod = errors.New("Incorrect signing method on token")
+	ErrUnknownKeyId         = errors.New("No verification key matches the token's kid")
+	ErrInvalidToken         = errors.New("Token is invalid")
+	ErrTokenExpired         = errors.New("Token is expired")
+	ErrTokenNotYetValid     = errors.New("Token is not valid yet")
+	ErrInvalidIssuedAt      = errors.New("Token's iat is missing or in the future")
+	ErrInvalidIssuer        = errors.New("Token's iss is not accepted")
+	ErrInvalidAudience      = errors.New("Token's aud is not accepted")
+	ErrRefreshRevoked       = errors.New("Refresh token has been revoked")
+	ErrRefreshReused        = errors.New("Refresh token has already been used")
+	ErrVerifyOnly           = errors.New("Server is not authorized to issue new tokens")
+	ErrNoClaims             = errors.New("No claims in request context")
+	ErrRefreshDenied        = errors.New("Auth server refused to refresh the tokens")
+	ErrRefreshUnavailable   = errors.New("Auth server couldn't be reached to refresh the tokens")
+	ErrIntrospectionFailed  = errors.New("Auth server couldn't introspect the token")
+)
+
+// Errors passed to the forbidden handler by RequireScopes, RequireRole and RequireClaim
+var (
+	ErrInsufficientScope = errors.New("Token doesn't have the required scope")
+	ErrForbidden         = errors.New("Token's claims don't allow access")
+)
+
+// Errors returned by the session methods, e.g. ListSessions
+var (
+	ErrNoSessionStore  = errors.New("No session store has been set")
+	ErrSessionNotFound = errors.New("Session not found")
+)
+
+// ErrTokenNotRevocable is returned by RevokeToken for a token that can't be revoked, i.e. one from
+// the client_credentials grant, which has no refresh token. It's valid until it expires.
+var ErrTokenNotRevocable = errors.New("Token has no refresh token to revoke")
+
+// Errors returned by an OAuth2Authenticator to reject a token request (see TokenEndpoint)
+var (
+	ErrInvalidClient         = errors.New("Client authentication failed")
+	ErrInvalidGrant          = errors.New("Grant is invalid")
+	ErrUnauthorizedClient    = errors.New("Client is not authorized to use the grant type")
+	ErrNoOAuth2Authenticator = errors.New("No OAuth2 authenticator has been set")
+	ErrNoIssuer              = errors.New("No Issuer has been set")
+	ErrAccessDenied          = errors.New("User denied the authorization request")
+	ErrCodeNotFound          = errors.New("Authorization code not found")
+)
+
+// ErrorHandlerFunc is like an http.HandlerFunc, but also receives the error that stopped the request
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// isUnauthorizedError returns true if the error was caused by the client's credentials,
+// rather than by a problem on our end
+func isUnauthorizedError(err error) bool {
+	switch err {
+	case ErrNoAuthToken, ErrNoRefreshToken, ErrNoCsrfToken, ErrCsrfMismatch,
+		ErrMalformedToken, ErrBadSignature, ErrInvalidSigningMethod, ErrInvalidToken,
+		ErrTokenExpired, ErrRefreshRevoked, ErrRefreshReused, ErrVerifyOnly, ErrNoClaims,
+		ErrUnknownKeyId, ErrTokenNotYetValid, ErrInvalidIssuedAt, ErrInvalidIssuer, ErrInvalidAudience,
+		ErrRefreshDenied:
+		return true
+	}
+	return false
+}
+
+// tokenError maps an error returned by jwt-go's parser to one of our errors
+func tokenError(err error) error {
+	ve, ok := err.(*jwtGo.ValidationError)
+	if !ok {
+		return ErrMalformedToken
+	}
+
+	// a bad signature takes precedence over everything else; the claims can't be trusted
+	switch {
+	case ve.Inner == ErrInvalidSigningMethod, ve.Inner == ErrUnknownKeyId:
+		return ve.Inner
+	case ve.Errors&jwtGo.ValidationErrorMalformed != 0:
+		return ErrMalformedToken
+	case ve.Errors&(jwtGo.ValidationErrorSignatureInvalid|jwtGo.ValidationErrorUnverifiable) != 0:
+		return ErrBadSignature
+	case ve.Errors&jwtGo.ValidationErrorExpired != 0:
+		return ErrTokenExpired
+	default:
+		return ErrInvalidToken
+	}
+}
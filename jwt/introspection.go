@@ -0,0 +1,199 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+const defaultIntrospectionTimeout = 10 * time.Second
+
+// IntrospectionEndpoint returns an OAuth2 token introspection endpoint
+// (https://tools.ietf.org/html/rfc7662), for services that can't verify tokens themselves.
+// Callers are authenticated like clients of the TokenEndpoint (see SetOAuth2Authenticator),
+// and POST the token in the "token" form value.
+//
+// The token is verified the same way the middleware verifies it, and checked against the token
+// id checker, unless it's a client_credentials token, which has nothing to check. The response is
+// {"active": false} if the token isn't valid. Otherwise it's the token's claims, including the
+// custom claims, along with "active": true and a "token_type" of "Bearer" for auth tokens, or
+// "refresh_token" for refresh tokens.
+func (a *Auth) IntrospectionEndpoint() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		if a.options.VerifyOnlyServer {
+			a.myLog("Server can't introspect tokens")
+			a.errorHandler(w, r, ErrVerifyOnly)
+			return
+		}
+		if _, _, ok := a.authenticateOAuth2Client(w, r); !ok {
+			return
+		}
+
+		tokenString := r.PostForm.Get("token")
+		if tokenString == "" {
+			writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "No token")
+			return
+		}
+
+		response, err := a.introspect(tokenString)
+		if err != nil {
+			a.myLog(err)
+			a.errorHandler(w, r, err)
+			return
+		}
+
+		writeOAuth2JSON(w, 200, response)
+	})
+}
+
+// introspect returns the introspection response for the token
+func (a *Auth) introspect(tokenString string) (map[string]interface{}, error) {
+	inactive := map[string]interface{}{"active": false}
+
+	claims, tokenType, err := a.parseTokenType(tokenString)
+	if err != nil {
+		a.myLog("Introspected token is not valid")
+		a.myLog(err)
+		return inactive, nil
+	}
+
+	// the auth token shares its id with its refresh token, so revoking the refresh token
+	// deactivates both. Auth tokens from the client_credentials grant have no refresh token, nor
+	// a family, and nothing is stored for them.
+	if revocable(claims, tokenType) && !a.checkTokenId(claims.Base().StandardClaims.Id) {
+		a.myLog("Introspected token has been revoked")
+		return inactive, nil
+	}
+
+	// the response is the token's claims, as json
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return nil, err
+	}
+
+	response["active"] = true
+	if tokenType == TokenTypeHintAccessToken {
+		response["token_type"] = "Bearer"
+	} else {
+		response["token_type"] = TokenTypeHintRefreshToken
+	}
+
+	return response, nil
+}
+
+// introspectionClient calls the auth server's introspection endpoint on behalf of a verify only server
+type introspectionClient struct {
+	url          string
+	clientId     string
+	clientSecret string
+	client       *http.Client
+}
+
+func newIntrospectionClient(introspectionURL string, clientId string, clientSecret string) *introspectionClient {
+	return &introspectionClient{
+		url:          introspectionURL,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: defaultIntrospectionTimeout},
+	}
+}
+
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type"`
+}
+
+// introspectToken asks the auth server whether the auth token is active, and returns its claims.
+// Like parseAuthToken, an expired token's claims are returned along with ErrTokenExpired, so that it can
+// be refreshed; they come from the token itself, and can't be trusted. The request to the auth server
+// is canceled along with ctx, i.e. the incoming request.
+func (a *Auth) introspectToken(ctx context.Context, tokenString string) (Claims, error) {
+	c := a.introspectionClient
+
+	form := url.Values{"token": {tokenString}, "token_type_hint": {TokenTypeHintAccessToken}}
+	req, err := http.NewRequest("POST", c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.clientId), url.QueryEscape(c.clientSecret))
+
+	a.myLog("Asking the auth server to introspect the token")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		a.myLog(err)
+		return nil, ErrIntrospectionFailed
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		a.myLog("Auth server failed to introspect the token: " + resp.Status)
+		return nil, ErrIntrospectionFailed
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		a.myLog(err)
+		return nil, ErrIntrospectionFailed
+	}
+	var introspection introspectionResponse
+	if err := json.Unmarshal(body, &introspection); err != nil {
+		a.myLog(err)
+		return nil, ErrIntrospectionFailed
+	}
+
+	if !introspection.Active {
+		a.myLog("Token is not active")
+		return a.expiredTokenClaims(tokenString)
+	}
+	// refresh tokens can't be used as auth tokens
+	if introspection.TokenType != "Bearer" {
+		a.myLog("Token is not an auth token")
+		return nil, ErrInvalidToken
+	}
+
+	claims := a.newClaims()
+	if err := json.Unmarshal(body, claims); err != nil {
+		a.myLog(err)
+		return nil, ErrIntrospectionFailed
+	}
+	return claims, a.validateClaims(claims.Base())
+}
+
+// expiredTokenClaims returns the unverified claims of an inactive token with ErrTokenExpired,
+// if it has expired, so that it can be refreshed by the auth server. Otherwise the token is invalid.
+func (a *Auth) expiredTokenClaims(tokenString string) (Claims, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	payload, err := jwtGo.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	claims := a.newClaims()
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if a.validateClaims(claims.Base()) == ErrTokenExpired {
+		return claims, ErrTokenExpired
+	}
+	return nil, ErrInvalidToken
+}
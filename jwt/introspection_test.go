@@ -0,0 +1,146 @@
+package jwt_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// introspect asks the harness' introspection endpoint whether the token is active
+func introspect(t *testing.T, h *jwttest.Harness, token string) bool {
+	t.Helper()
+
+	w := postForm(h.Auth.IntrospectionEndpoint(), "app", url.Values{"token": {token}})
+	var response struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Active
+}
+
+func TestIntrospectRevokedToken(t *testing.T) {
+	h := newOAuth2Harness(t)
+	tokens := passwordGrant(t, h)
+
+	if !introspect(t, h, tokens.AccessToken) || !introspect(t, h, tokens.RefreshToken) {
+		t.Fatal("tokens aren't active")
+	}
+
+	if err := h.Auth.RevokeToken(tokens.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if introspect(t, h, tokens.AccessToken) || introspect(t, h, tokens.RefreshToken) {
+		t.Error("revoked tokens are active")
+	}
+}
+
+func TestIntrospectClientCredentialsToken(t *testing.T) {
+	h := newOAuth2Harness(t)
+
+	// the token store has nothing for the client's token, which has no refresh token
+	code, response := grant(t, h, "app", url.Values{"grant_type": {"client_credentials"}})
+	if code != 200 || response.AccessToken == "" {
+		t.Fatalf("code = %d, response = %+v", code, response)
+	}
+	if !introspect(t, h, response.AccessToken) {
+		t.Error("client_credentials token isn't active")
+	}
+
+	h.Clock.Advance(time.Hour)
+	if introspect(t, h, response.AccessToken) {
+		t.Error("expired client_credentials token is active")
+	}
+}
+
+// newIntrospectingServer returns a verify only server that asks the introspection url about tokens,
+// and the error that its handlers were last called with
+func newIntrospectingServer(t *testing.T, introspectionURL string) (*jwt.Auth, *error) {
+	var verifier jwt.Auth
+	if err := jwt.New(&verifier, jwt.Options{
+		SigningMethodString:   "HS256",
+		VerifyOnlyServer:      true,
+		IntrospectionURL:      introspectionURL,
+		IntrospectionClientId: "app",
+		IntrospectionSecret:   "secret",
+		AuthorizationHeader:   true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var handlerErr error
+	handler := func(w http.ResponseWriter, r *http.Request, err error) {
+		handlerErr = err
+		http.Error(w, err.Error(), 401)
+	}
+	verifier.SetUnauthorizedHandlerWithError(handler)
+	verifier.SetErrorHandlerWithError(handler)
+	return &verifier, &handlerErr
+}
+
+func TestIntrospectionURL(t *testing.T) {
+	h := newOAuth2Harness(t, jwt.Options{AuthorizationHeader: true})
+	authServer := httptest.NewServer(h.Auth.IntrospectionEndpoint())
+	defer authServer.Close()
+	verifier, handlerErr := newIntrospectingServer(t, authServer.URL)
+
+	tokens := h.Issue(bob)
+	if code := verify(verifier, tokens.AuthToken); code != 200 {
+		t.Fatalf("active token: code = %d, error = %v", code, *handlerErr)
+	}
+
+	// the refresh token is active, but it isn't an auth token
+	if code := verify(verifier, tokens.RefreshToken); code != 401 || *handlerErr != jwt.ErrInvalidToken {
+		t.Errorf("refresh token: code = %d, error = %v", code, *handlerErr)
+	}
+
+	h.Revoke(tokens)
+	if code := verify(verifier, tokens.AuthToken); code != 401 || *handlerErr != jwt.ErrInvalidToken {
+		t.Errorf("inactive token: code = %d, error = %v", code, *handlerErr)
+	}
+
+	authServer.Close()
+	if code := verify(verifier, h.Issue(bob).AuthToken); code != 401 || *handlerErr != jwt.ErrIntrospectionFailed {
+		t.Errorf("unreachable auth server: code = %d, error = %v", code, *handlerErr)
+	}
+}
+
+func TestIntrospectionUsesRequestContext(t *testing.T) {
+	h := newOAuth2Harness(t, jwt.Options{AuthorizationHeader: true})
+	// the auth server hangs until the test is over
+	release := make(chan struct{})
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer authServer.Close()
+	defer close(release)
+	verifier, handlerErr := newIntrospectingServer(t, authServer.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	r.Header.Set("Authorization", "Bearer "+h.Issue(bob).AuthToken)
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		verifier.Handler(okHandler).ServeHTTP(w, r)
+		done <- w.Code
+	}()
+	select {
+	case code := <-done:
+		if code != 401 || *handlerErr != jwt.ErrIntrospectionFailed {
+			t.Errorf("code = %d, error = %v", code, *handlerErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("introspection outlived the request")
+	}
+}
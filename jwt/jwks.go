@@ -0,0 +1,336 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONWebKey is a public key, as described by https://tools.ietf.org/html/rfc7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP (Ed25519) keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a set of public keys
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// how long the JWK Set may be cached by clients
+const jwksMaxAge = 5 * time.Minute
+
+// defaults for fetching a remote JWK Set
+const defaultJWKSCacheTime = time.Hour
+const defaultJWKSTimeout = 10 * time.Second
+
+// the JWK Set is cached for at least this long, and isn't fetched again any sooner after a failed
+// fetch. An unknown kid won't trigger a fetch more often than this, either.
+const minJWKSRefetchInterval = time.Minute
+
+// JWKSHandler serves the verification keys as a JWK Set. HMAC keys are secret, and are never served.
+func (a *Auth) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		set, err := a.JSONWebKeySet()
+		if err != nil {
+			a.myLog(err)
+			a.errorHandler(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwksMaxAge.Seconds())))
+		json.NewEncoder(w).Encode(set)
+	})
+}
+
+// JSONWebKeySet returns the public verification keys
+func (a *Auth) JSONWebKeySet() (JSONWebKeySet, error) {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	a.keys.mu.RLock()
+	defer a.keys.mu.RUnlock()
+
+	for kid, verifyKey := range a.keys.verifyKeys {
+		if _, isHMAC := verifyKey.([]byte); isHMAC {
+			continue
+		}
+
+		jwk, err := newJSONWebKey(verifyKey)
+		if err != nil {
+			return set, err
+		}
+		jwk.Kid = kid
+		jwk.Use = "sig"
+		jwk.Alg = a.options.SigningMethodString
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+func newJSONWebKey(verifyKey interface{}) (JSONWebKey, error) {
+	switch key := verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JSONWebKey{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), size)),
+			Y:   base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), size)),
+		}, nil
+	case ed25519.PublicKey:
+		// https://tools.ietf.org/html/rfc8037#section-2
+		return JSONWebKey{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	default:
+		return JSONWebKey{}, fmt.Errorf("Key type %T can't be published", verifyKey)
+	}
+}
+
+// PublicKey returns the public key that the JWK describes
+func (jwk JSONWebKey) PublicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("Unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// a point that's off the curve can leak the private key of whoever uses it
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC key is not on the " + jwk.Crv + " curve")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("Unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New("Unsupported key type: " + jwk.Kty)
+	}
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// remoteKeySet keeps a keyring up to date with a JWK Set served by another server
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+	clock  Clock
+
+	mu        sync.Mutex
+	expires   time.Time
+	lastFetch time.Time
+	hasKeys   bool
+	// set while the JWK Set is being fetched, so that concurrent requests share the fetch
+	fetch *jwksFetch
+}
+
+// jwksFetch is a fetch of the JWK Set that is in progress. done is closed once it has finished.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func newRemoteKeySet(url string, clock Clock) *remoteKeySet {
+	return &remoteKeySet{
+		url:    url,
+		client: &http.Client{Timeout: defaultJWKSTimeout},
+		clock:  clock,
+	}
+}
+
+// update fetches the JWK Set if the cached copy has expired, or if a token has an unknown kid.
+// The lock isn't held while fetching: requests for keys that we already have carry on with the
+// cached keys, and the rest wait for the one fetch that's in progress.
+func (s *remoteKeySet) update(keys *keyring, signingMethodString string, unknownKid bool) error {
+	s.mu.Lock()
+	now := s.clock.Now()
+	if s.fetch == nil && now.Before(s.expires) && (!unknownKid || now.Sub(s.lastFetch) < minJWKSRefetchInterval) {
+		s.mu.Unlock()
+		return nil
+	}
+
+	fetch := s.fetch
+	if fetch == nil {
+		fetch = &jwksFetch{done: make(chan struct{})}
+		s.fetch = fetch
+		s.lastFetch = now
+		go s.run(fetch, keys, signingMethodString)
+	}
+	hasKeys := s.hasKeys
+	s.mu.Unlock()
+
+	// keep serving the cached keys while they're being fetched again
+	if hasKeys && !unknownKid {
+		return nil
+	}
+
+	<-fetch.done
+	return fetch.err
+}
+
+// run fetches the JWK Set and updates the keyring. If the fetch fails, the cached keys are kept,
+// and it isn't retried for minJWKSRefetchInterval.
+func (s *remoteKeySet) run(fetch *jwksFetch, keys *keyring, signingMethodString string) {
+	verifyKeys, cache, err := s.fetchKeys(signingMethodString)
+
+	s.mu.Lock()
+	now := s.clock.Now()
+	if err != nil {
+		s.expires = now.Add(minJWKSRefetchInterval)
+	} else {
+		keys.replaceVerifyKeys(verifyKeys)
+		s.hasKeys = true
+		// don't let a no-cache or max-age=0 response make us fetch the keys on every request
+		if cache < minJWKSRefetchInterval {
+			cache = minJWKSRefetchInterval
+		}
+		s.expires = now.Add(cache)
+	}
+	s.fetch = nil
+	s.mu.Unlock()
+
+	fetch.err = err
+	close(fetch.done)
+}
+
+// fetchKeys fetches the JWK Set, and returns its keys that can be used with the signing method
+// and how long they may be cached for
+func (s *remoteKeySet) fetchKeys(signingMethodString string) (map[string]interface{}, time.Duration, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, 0, fmt.Errorf("Fetching JWK Set from %s returned status %d", s.url, resp.StatusCode)
+	}
+
+	var set JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, err
+	}
+
+	verifyKeys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		verifyKey, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		// skip keys that can't be used with our signing method
+		if checkKeyTypes(signingMethodString, nil, verifyKey) != nil {
+			continue
+		}
+		verifyKeys[jwk.Kid] = verifyKey
+	}
+	if len(verifyKeys) == 0 {
+		return nil, 0, errors.New("No usable keys in the JWK Set from " + s.url)
+	}
+
+	return verifyKeys, cacheTime(resp.Header.Get("Cache-Control")), nil
+}
+
+// cacheTime returns how long a response may be cached, according to its Cache-Control header
+func cacheTime(cacheControl string) time.Duration {
+	if cacheControl == "" {
+		return defaultJWKSCacheTime
+	}
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultJWKSCacheTime
+}
@@ -0,0 +1,229 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// jwksServer serves an auth server's JWK Set with Cache-Control: no-cache, and counts the fetches
+type jwksServer struct {
+	*httptest.Server
+	auth *jwt.Auth
+
+	mu      sync.Mutex
+	fetches int
+	fail    bool
+	block   chan struct{}
+	fetched chan struct{}
+}
+
+func newJWKSServer(t *testing.T, auth *jwt.Auth) *jwksServer {
+	s := &jwksServer{auth: auth, fetched: make(chan struct{}, 10)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.fetches++
+		fail, block := s.fail, s.block
+		s.mu.Unlock()
+		defer func() { s.fetched <- struct{}{} }()
+
+		if block != nil {
+			<-block
+		}
+		if fail {
+			http.Error(w, "Service Unavailable", 503)
+			return
+		}
+
+		set, err := s.auth.JSONWebKeySet()
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// waitForFetch waits for the server to finish responding to a fetch
+func (s *jwksServer) waitForFetch(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.fetched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the JWK Set wasn't fetched")
+	}
+}
+
+// newJWKSServers returns an auth server, a verify only server that gets its keys from the auth
+// server's JWK Set, and an auth token
+func newJWKSServers(t *testing.T) (*jwksServer, *jwt.Auth, *jwttest.FakeClock, string) {
+	clock := jwttest.NewFakeClock(time.Now())
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var authServer jwt.Auth
+	if err := jwt.New(&authServer, jwt.Options{
+		SigningMethodString: "ES256",
+		SigningKey:          key,
+		AuthorizationHeader: true,
+		AuthTokenValidTime:  time.Hour,
+		Clock:               clock,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	server := newJWKSServer(t, &authServer)
+
+	var verifier jwt.Auth
+	if err := jwt.New(&verifier, jwt.Options{
+		SigningMethodString: "ES256",
+		VerifyOnlyServer:    true,
+		JWKSURL:             server.URL,
+		AuthorizationHeader: true,
+		Clock:               clock,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err := authServer.IssueNewTokens(w, bob); err != nil {
+		t.Fatal(err)
+	}
+	return server, &verifier, clock, w.Header().Get("Auth-Token")
+}
+
+func verify(auth *jwt.Auth, authToken string) int {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+authToken)
+	w := httptest.NewRecorder()
+	auth.Handler(okHandler).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRemoteKeySetMinimumCacheTime(t *testing.T) {
+	server, verifier, clock, authToken := newJWKSServers(t)
+
+	for i := 0; i < 5; i++ {
+		if code := verify(verifier, authToken); code != 200 {
+			t.Fatalf("code = %d", code)
+		}
+	}
+	clock.Advance(59 * time.Second)
+	verify(verifier, authToken)
+	if fetches := server.count(); fetches != 1 {
+		t.Errorf("a no-cache JWK Set was fetched %d times, want 1", fetches)
+	}
+
+	clock.Advance(2 * time.Second)
+	if code := verify(verifier, authToken); code != 200 {
+		t.Fatalf("code = %d", code)
+	}
+	server.waitForFetch(t)
+	server.waitForFetch(t)
+	if fetches := server.count(); fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+}
+
+func TestRemoteKeySetServesCachedKeys(t *testing.T) {
+	server, verifier, clock, authToken := newJWKSServers(t)
+	if code := verify(verifier, authToken); code != 200 {
+		t.Fatalf("code = %d", code)
+	}
+	server.waitForFetch(t)
+
+	// the auth server hangs, and then fails
+	block := make(chan struct{})
+	server.mu.Lock()
+	server.block, server.fail = block, true
+	server.mu.Unlock()
+	clock.Advance(2 * time.Minute)
+
+	codes := make(chan int)
+	go func() { codes <- verify(verifier, authToken) }()
+	select {
+	case code := <-codes:
+		if code != 200 {
+			t.Errorf("code while fetching = %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request waited for the JWK Set to be fetched")
+	}
+
+	close(block)
+	server.waitForFetch(t)
+
+	// the cached keys are kept, and the fetch isn't retried right away
+	clock.Advance(30 * time.Second)
+	for i := 0; i < 5; i++ {
+		if code := verify(verifier, authToken); code != 200 {
+			t.Errorf("code after a failed fetch = %d", code)
+		}
+	}
+	if fetches := server.count(); fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+}
+
+func TestRemoteKeySetUnknownKid(t *testing.T) {
+	server, verifier, _, authToken := newJWKSServers(t)
+	server.mu.Lock()
+	server.fail = true
+	server.mu.Unlock()
+
+	// there are no cached keys, so the request has to wait for the fetch
+	if code := verify(verifier, authToken); code != 401 {
+		t.Errorf("code = %d, want 401", code)
+	}
+	server.waitForFetch(t)
+
+	// and the failed fetch isn't retried for every request
+	for i := 0; i < 5; i++ {
+		verify(verifier, authToken)
+	}
+	if fetches := server.count(); fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+}
+
+func TestJSONWebKeyOnCurve(t *testing.T) {
+	auth := newSigningAuth(t, "ES256", generateECKey(t))
+	set, err := auth.JSONWebKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := set.Keys[0]
+	if _, err := jwk.PublicKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a point that's off the curve
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y[len(y)-1] ^= 1
+	jwk.Y = base64.RawURLEncoding.EncodeToString(y)
+	if _, err := jwk.PublicKey(); err == nil {
+		t.Error("a key that's off the curve was accepted")
+	}
+}
@@ -5,6 +5,8 @@ package jwt
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -24,6 +26,14 @@ type ClaimsType struct {
 	jwtGo.StandardClaims
 	Csrf         string
 	CustomClaims map[string]interface{}
+	// FamilyId is shared by every refresh token that descends from the same login
+	FamilyId string `json:",omitempty"`
+	// Scope is a space delimited list of scopes, see RequireScopes
+	Scope string `json:"scope,omitempty"`
+	// ClientId is the OAuth2 client that the tokens were issued to, see TokenEndpoint
+	ClientId string `json:"client_id,omitempty"`
+	// AuthTime is when the user logged in, in Unix time. Refreshed tokens keep it.
+	AuthTime int64 `json:"auth_time,omitempty"`
 }
 
 // Options is a struct for specifying configuration options
@@ -31,11 +41,30 @@ type Options struct {
 	SigningMethodString   string
 	PrivateKeyLocation    string
 	PublicKeyLocation     string
+	PrivateKeyBytes       []byte
+	PublicKeyBytes        []byte
+	SigningKey            crypto.Signer
+	VerificationKey       crypto.PublicKey
 	HMACKey               []byte
+	KeyId                 string
 	VerifyOnlyServer      bool
+	JWKSURL               string
+	RefreshURL            string
+	IntrospectionURL      string
+	IntrospectionClientId string
+	IntrospectionSecret   string
 	BearerTokens          bool
+	AuthorizationHeader   bool
+	CookieOptions         CookieOptions
 	RefreshTokenValidTime time.Duration
 	AuthTokenValidTime    time.Duration
+	RotateRefreshTokens   bool
+	RefreshEndpoint       bool
+	Issuer                string
+	Audience              []string
+	Leeway                time.Duration
+	Clock                 Clock
+	RequireIssuedAt       bool
 	Debug                 bool
 	IsDevEnv              bool
 }
@@ -56,36 +85,105 @@ func defaultCheckTokenId(tokenId string) bool {
 
 type TokenIdChecker func(tokenId string) bool
 
-func defaultErrorHandler(w http.ResponseWriter, r *http.Request) {
+func defaultTokenFamilyRevoker(familyId string) error {
+	return nil
+}
+
+type TokenFamilyRevoker func(familyId string) error
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	http.Error(w, "Internal Server Error", 500)
 	return
 }
 
-func defaultUnauthorizedHandler(w http.ResponseWriter, r *http.Request) {
+func defaultUnauthorizedHandler(w http.ResponseWriter, r *http.Request, err error) {
 	http.Error(w, "Unauthorized", 401)
 	return
 }
 
 // this is a general json struct for when bearer tokens are used
 type bearerTokensStruct struct {
-	Auth_Token    string `json: "Auth_Token"`
-	Refresh_Token string `json: "Refresh_Token"`
+	Auth_Token    string `json:"Auth_Token"`
+	Refresh_Token string `json:"Refresh_Token"`
 }
 
+// the names of the response headers that carry new tokens when the auth token is sent in the
+// Authorization header. The refresh token is read from the request header of the same name.
+// Proxies like nginx drop headers with underscores, so these use hyphens.
+const (
+	authTokenHeader    = "Auth-Token"
+	refreshTokenHeader = "Refresh-Token"
+)
+
+// the typ headers of auth and refresh tokens, which tell them apart, so that neither can be used
+// in place of the other
+// https://tools.ietf.org/html/rfc9068#section-2.1
+const (
+	authTokenType    = "at+jwt"
+	refreshTokenType = "refresh+jwt"
+)
+
+// token types, as used in token_type_hint
+// https://tools.ietf.org/html/rfc7009#section-2.1
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// error codes for the WWW-Authenticate challenge
+// https://tools.ietf.org/html/rfc6750#section-3.1
+const (
+	bearerErrorInvalidToken      = "invalid_token"
+	bearerErrorInsufficientScope = "insufficient_scope"
+)
+
 // Auth is a middleware that provides jwt based authentication.
 type Auth struct {
-	signKey   interface{}
-	verifyKey interface{}
+	// the active signing key, and every key that tokens can be verified with
+	keys *keyring
+
+	// set when a verify only server gets its keys from a JWK Set
+	remoteKeys *remoteKeySet
+
+	// set when a verify only server asks the auth server to refresh expired tokens
+	refreshClient *refreshClient
+
+	// set when a verify only server asks the auth server to introspect tokens, instead of verifying them
+	introspectionClient *introspectionClient
 
 	options Options
 
 	// Handlers for when an error occurs
-	errorHandler        http.Handler
-	unauthorizedHandler http.Handler
+	errorHandler        ErrorHandlerFunc
+	unauthorizedHandler ErrorHandlerFunc
+	forbiddenHandler    ErrorHandlerFunc
 
 	// funcs for checking and revoking refresh tokens
 	revokeRefreshToken TokenRevoker
 	checkTokenId       TokenIdChecker
+	revokeTokenFamily  TokenFamilyRevoker
+
+	// optional store that issued refresh tokens are registered with
+	tokenStore TokenStore
+
+	// optional store that sessions are recorded in
+	sessionStore SessionStore
+
+	// checks the credentials presented to the TokenEndpoint
+	oauth2Authenticator OAuth2Authenticator
+
+	// fills in the claims of id tokens
+	userInfoProvider UserInfoProvider
+
+	// authorization codes that are waiting to be exchanged, and where clients may be redirected to
+	codeStore    AuthorizationCodeStore
+	redirectURIs *redirectURIRegistry
+
+	// the tokens that refresh tokens were just rotated to, for concurrent requests
+	rotations *rotationCache
+
+	// makes the claims values that tokens are decoded into
+	newClaims ClaimsFactory
 }
 
 // New constructs a new Auth instance with supplied options.
@@ -106,10 +204,40 @@ func New(auth *Auth, options ...Options) error {
 		o.AuthTokenValidTime = defaultAuthTokenValidTime
 	}
 
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+
+	if err := checkCookieOptions(&o); err != nil {
+		return err
+	}
+
+	if o.RefreshURL != "" && !o.VerifyOnlyServer {
+		return errors.New("A RefreshURL can only be used by a VerifyOnlyServer")
+	}
+	if o.IntrospectionURL != "" && !o.VerifyOnlyServer {
+		return errors.New("An IntrospectionURL can only be used by a VerifyOnlyServer")
+	}
+
 	// create the sign and verify keys
 	var signKey interface{}
 	var verifyKey interface{}
-	if o.SigningMethodString == "HS256" || o.SigningMethodString == "HS384" || o.SigningMethodString == "HS512" {
+	if o.VerifyOnlyServer && o.IntrospectionURL != "" {
+		// tokens are verified by the auth server, so there is nothing to load
+		if o.JWKSURL != "" {
+			return errors.New("A JWKSURL can't be used along with an IntrospectionURL")
+		}
+
+	} else if o.VerifyOnlyServer && o.JWKSURL != "" {
+		// the keys are fetched from the auth server's JWK Set, so there is nothing to load
+		if strings.HasPrefix(o.SigningMethodString, "HS") {
+			return errors.New("A JWKSURL can't be used with an HMAC-SHA signing method")
+		}
+		if err := checkKeyTypes(o.SigningMethodString, nil, nil); err != nil {
+			return err
+		}
+
+	} else if o.SigningMethodString == "HS256" || o.SigningMethodString == "HS384" || o.SigningMethodString == "HS512" {
 		if len(o.HMACKey) == 0 {
 			return errors.New("When using an HMAC-SHA signing method, please provide a HMACKey")
 		}
@@ -118,84 +246,87 @@ func New(auth *Auth, options ...Options) error {
 		}
 		verifyKey = o.HMACKey
 
-	} else if o.SigningMethodString == "RS256" || o.SigningMethodString == "RS384" || o.SigningMethodString == "RS512" {
-		// check to make sure the provided options are valid
-		if (o.PrivateKeyLocation == "" && !o.VerifyOnlyServer) || o.PublicKeyLocation == "" {
-			return errors.New("Private and public key locations are required!")
-		}
-
-		// read the key files
-		if !o.VerifyOnlyServer {
-			signBytes, err := ioutil.ReadFile(o.PrivateKeyLocation)
-			if err != nil {
-				return err
-			}
-
-			signKey, err = jwtGo.ParseRSAPrivateKeyFromPEM(signBytes)
-			if err != nil {
-				return err
-			}
-		}
-
-		verifyBytes, err := ioutil.ReadFile(o.PublicKeyLocation)
+	} else if o.SigningMethodString == "RS256" || o.SigningMethodString == "RS384" || o.SigningMethodString == "RS512" ||
+		o.SigningMethodString == "PS256" || o.SigningMethodString == "PS384" || o.SigningMethodString == "PS512" ||
+		o.SigningMethodString == "ES256" || o.SigningMethodString == "ES384" || o.SigningMethodString == "ES512" ||
+		o.SigningMethodString == "EdDSA" {
+		var err error
+		signKey, verifyKey, err = loadKeys(o)
 		if err != nil {
 			return err
 		}
-
-		verifyKey, err = jwtGo.ParseRSAPublicKeyFromPEM(verifyBytes)
-		if err != nil {
+		if err := checkKeyTypes(o.SigningMethodString, signKey, verifyKey); err != nil {
 			return err
 		}
 
-	} else if o.SigningMethodString == "ES256" || o.SigningMethodString == "ES384" || o.SigningMethodString == "ES512" {
-		// check to make sure the provided options are valid
-		if (o.PrivateKeyLocation == "" && !o.VerifyOnlyServer) || o.PublicKeyLocation == "" {
-			return errors.New("Private and public key locations are required!")
-		}
-
-		// read the key files
-		if !o.VerifyOnlyServer {
-			signBytes, err := ioutil.ReadFile(o.PrivateKeyLocation)
-			if err != nil {
-				return err
-			}
-
-			signKey, err = jwtGo.ParseECPrivateKeyFromPEM(signBytes)
-			if err != nil {
-				return err
-			}
-		}
-
-		verifyBytes, err := ioutil.ReadFile(o.PublicKeyLocation)
-		if err != nil {
-			return err
-		}
+	} else {
+		return errors.New("Signing method string not recognized!")
+	}
 
-		verifyKey, err = jwtGo.ParseECPublicKeyFromPEM(verifyBytes)
+	// tokens are stamped with a kid so that keys can be rotated later.
+	// If one hasn't been provided, derive it from the public key.
+	kid := o.KeyId
+	if _, isHMAC := verifyKey.([]byte); kid == "" && !isHMAC && verifyKey != nil {
+		var err error
+		kid, err = keyThumbprint(verifyKey)
 		if err != nil {
 			return err
 		}
+	}
 
+	auth.remoteKeys = nil
+	if verifyKey != nil {
+		auth.keys = newKeyring(kid, signKey, verifyKey)
 	} else {
-		return errors.New("Signing method string not recognized!")
+		auth.keys = &keyring{verifyKeys: make(map[string]interface{})}
+		if o.JWKSURL != "" {
+			auth.remoteKeys = newRemoteKeySet(o.JWKSURL, o.Clock)
+		}
+	}
+	auth.refreshClient = nil
+	if o.RefreshURL != "" {
+		auth.refreshClient = newRefreshClient(o.RefreshURL)
+	}
+	auth.introspectionClient = nil
+	if o.IntrospectionURL != "" {
+		auth.introspectionClient = newIntrospectionClient(o.IntrospectionURL, o.IntrospectionClientId, o.IntrospectionSecret)
 	}
-
-	auth.signKey = signKey
-	auth.verifyKey = verifyKey
 	auth.options = o
-	auth.errorHandler = http.HandlerFunc(defaultErrorHandler)
-	auth.unauthorizedHandler = http.HandlerFunc(defaultUnauthorizedHandler)
+	auth.errorHandler = ErrorHandlerFunc(defaultErrorHandler)
+	auth.unauthorizedHandler = ErrorHandlerFunc(defaultUnauthorizedHandler)
+	auth.forbiddenHandler = ErrorHandlerFunc(defaultForbiddenHandler)
 	auth.revokeRefreshToken = TokenRevoker(defaultTokenRevoker)
 	auth.checkTokenId = TokenIdChecker(defaultCheckTokenId)
+	auth.revokeTokenFamily = TokenFamilyRevoker(defaultTokenFamilyRevoker)
+	auth.newClaims = ClaimsFactory(defaultClaimsFactory)
+
+	codeStore := NewMemoryAuthorizationCodeStore()
+	codeStore.SetClock(o.Clock)
+	auth.codeStore = codeStore
+	auth.redirectURIs = &redirectURIRegistry{uris: make(map[string][]string)}
+	auth.rotations = newRotationCache()
 
 	return nil
 }
 
 // add methods to allow the changing of default functions
 func (a *Auth) SetErrorHandler(handler http.Handler) {
-	a.errorHandler = handler
+	a.errorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handler.ServeHTTP(w, r)
+	}
 }
 func (a *Auth) SetUnauthorizedHandler(handler http.Handler) {
+	a.unauthorizedHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// SetErrorHandlerWithError and SetUnauthorizedHandlerWithError are like SetErrorHandler and
+// SetUnauthorizedHandler, but the handler also receives the error, e.g. ErrCsrfMismatch
+func (a *Auth) SetErrorHandlerWithError(handler ErrorHandlerFunc) {
+	a.errorHandler = handler
+}
+func (a *Auth) SetUnauthorizedHandlerWithError(handler ErrorHandlerFunc) {
 	a.unauthorizedHandler = handler
 }
 func (a *Auth) SetRevokeTokenFunction(revoker TokenRevoker) {
@@ -204,13 +335,27 @@ func (a *Auth) SetRevokeTokenFunction(revoker TokenRevoker) {
 func (a *Auth) SetCheckTokenIdFunction(checker TokenIdChecker) {
 	a.checkTokenId = checker
 }
+func (a *Auth) SetRevokeTokenFamilyFunction(revoker TokenFamilyRevoker) {
+	a.revokeTokenFamily = revoker
+}
+
+// SetTokenStore registers a store that newly issued refresh tokens are saved to.
+// The store's check and revoke methods replace the token id checker and revokers.
+// If the store has a SetClock method, like MemoryTokenStore, it's given the Options.Clock.
+func (a *Auth) SetTokenStore(store TokenStore) {
+	a.setStoreClock(store)
+	a.tokenStore = store
+	a.revokeRefreshToken = TokenRevoker(store.RevokeToken)
+	a.checkTokenId = TokenIdChecker(store.CheckToken)
+	a.revokeTokenFamily = TokenFamilyRevoker(store.RevokeTokenFamily)
+}
 
 // Handler implements the http.HandlerFunc for integration with the standard net/http lib.
 func (a *Auth) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Process the request. If it returns an error,
 		// that indicates the request should not continue.
-		err := a.Process(w, r)
+		r, err := a.process(w, r)
 
 		// If there was an error, do not continue.
 		if err != nil {
@@ -223,7 +368,7 @@ func (a *Auth) Handler(h http.Handler) http.Handler {
 
 // HandlerFuncWithNext is a special implementation for Negroni, but could be used elsewhere.
 func (a *Auth) HandlerFuncWithNext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	err := a.Process(w, r)
+	r, err := a.process(w, r)
 
 	// If there was an error, do not call next.
 	if err == nil && next != nil {
@@ -232,25 +377,49 @@ func (a *Auth) HandlerFuncWithNext(w http.ResponseWriter, r *http.Request, next
 }
 
 // Process runs the actual checks and returns an error if the middleware chain should stop.
+// On success, the request's context carries the verified claims (see ClaimsFromContext).
 func (a *Auth) Process(w http.ResponseWriter, r *http.Request) error {
+	newRequest, err := a.process(w, r)
+	if err != nil {
+		return err
+	}
+
+	// update the caller's request in place, so frameworks that hold on to it see the claims, too
+	*r = *newRequest
+	return nil
+}
+
+// process runs the checks and returns a shallow copy of the request whose context carries the claims
+func (a *Auth) process(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
 	// cookies aren't included with options, so simply pass through
 	if r.Method == "OPTIONS" {
 		a.myLog("Method is OPTIONS")
-		return nil
+		return r, nil
 	}
 
 	var authTokenValue string
 	var refreshTokenValue string
 
 	// read cookies
-	if a.options.BearerTokens {
+	if a.options.AuthorizationHeader {
+		// tokens are in the Authorization and Refresh-Token headers
+		authTokenValue = grabBearerTokenFromReq(r)
+		if authTokenValue == "" {
+			a.myLog("Unauthorized attempt! No bearer token")
+			// no error code when the request lacks any authentication information
+			a.setBearerChallenge(w, "", "")
+			a.unauthorizedHandler(w, r, ErrNoAuthToken)
+			return nil, ErrNoAuthToken
+		}
+		refreshTokenValue = r.Header.Get(refreshTokenHeader)
+	} else if a.options.BearerTokens {
 		// tokens are not in cookies
 		if r.Header.Get("Content-Type") == "application/json" {
 			content, err := ioutil.ReadAll(r.Body)
 			if err != nil {
 				a.myLog("Err decoding bearer tokens json \n" + err.Error())
-				a.errorHandler.ServeHTTP(w, r)
-				return errors.New("Internal Server Error")
+				a.errorHandler(w, r, err)
+				return nil, err
 			}
 			r.Body = ioutil.NopCloser(bytes.NewReader(content))
 
@@ -258,8 +427,8 @@ func (a *Auth) Process(w http.ResponseWriter, r *http.Request) error {
 			err = json.Unmarshal(content, &bearerTokens)
 			if err != nil {
 				a.myLog("Err decoding bearer tokens json \n" + err.Error())
-				a.errorHandler.ServeHTTP(w, r)
-				return errors.New("Internal Server Error")
+				a.errorHandler(w, r, err)
+				return nil, err
 			}
 			authTokenValue = bearerTokens.Auth_Token
 			refreshTokenValue = bearerTokens.Refresh_Token
@@ -269,56 +438,77 @@ func (a *Auth) Process(w http.ResponseWriter, r *http.Request) error {
 			refreshTokenValue = strings.Join(r.Form["Refresh_Token"], "")
 		}
 	} else {
-		AuthCookie, authErr := r.Cookie("AuthToken")
+		AuthCookie, authErr := r.Cookie(a.authCookieName())
 		if authErr == http.ErrNoCookie {
 			a.myLog("Unauthorized attempt! No auth cookie")
-			a.NullifyTokens(&w, r)
-			a.unauthorizedHandler.ServeHTTP(w, r)
-			return errors.New("Unauthorized")
+			// an expired auth cookie is dropped by the browser, but the client can still
+			// refresh it, so don't clear the refresh cookie when it isn't sent here anyway
+			if !a.options.RefreshEndpoint && !a.refreshCookieRestricted() {
+				a.NullifyTokens(&w, r)
+			}
+			a.unauthorizedHandler(w, r, ErrNoAuthToken)
+			return nil, ErrNoAuthToken
 		} else if authErr != nil {
 			a.myLog(authErr)
 			a.NullifyTokens(&w, r)
-			a.errorHandler.ServeHTTP(w, r)
-			return errors.New("Internal Server Error")
+			a.errorHandler(w, r, authErr)
+			return nil, authErr
 		}
 		authTokenValue = AuthCookie.Value
 
-		RefreshCookie, refreshErr := r.Cookie("RefreshToken")
-		if refreshErr == http.ErrNoCookie {
+		RefreshCookie, refreshErr := r.Cookie(a.refreshCookieName())
+		if refreshErr == http.ErrNoCookie && (a.options.RefreshEndpoint || a.refreshCookieRestricted()) {
+			// the refresh cookie is only sent to its own path, so we can only check the auth token
+			a.myLog("No refresh cookie. It's restricted to " + a.options.CookieOptions.RefreshTokenPath)
+		} else if refreshErr == http.ErrNoCookie {
 			a.myLog("Unauthorized attempt! No refresh cookie")
 			a.NullifyTokens(&w, r)
-			a.unauthorizedHandler.ServeHTTP(w, r)
-			return errors.New("Unauthorized")
+			a.unauthorizedHandler(w, r, ErrNoRefreshToken)
+			return nil, ErrNoRefreshToken
 		} else if refreshErr != nil {
 			a.myLog(refreshErr)
 			a.NullifyTokens(&w, r)
-			a.errorHandler.ServeHTTP(w, r)
-			return errors.New("Internal Server Error")
+			a.errorHandler(w, r, refreshErr)
+			return nil, refreshErr
+		}
+		if RefreshCookie != nil {
+			refreshTokenValue = RefreshCookie.Value
 		}
-		refreshTokenValue = RefreshCookie.Value
+	}
+
+	// refresh tokens are only accepted by the RefreshHandler
+	if a.options.RefreshEndpoint {
+		refreshTokenValue = ""
 	}
 
 	// grab the csrf token
 	requestCsrfToken := grabCsrfFromReq(r)
 
 	// check the jwt's for validity
-	authTokenString, refreshTokenString, csrfSecret, err := a.checkAndRefreshTokens(authTokenValue, refreshTokenValue, requestCsrfToken)
+	authTokenString, refreshTokenString, csrfSecret, claims, err := a.checkAndRefreshTokens(r.Context(), authTokenValue, refreshTokenValue, requestCsrfToken)
+	if err == nil && authTokenString != authTokenValue {
+		// a new auth token has been issued, so the session has been refreshed. The refresh
+		// token's expiry is extended on most requests, which doesn't count.
+		err = a.recordSession(r, claims)
+	}
+	if err == ErrVerifyOnly && a.refreshClient != nil {
+		// the auth token has expired, and we can't issue a new one ourselves.
+		// The auth server records the session.
+		authTokenString, refreshTokenString, csrfSecret, claims, err = a.refreshFromAuthServer(r, refreshTokenValue, requestCsrfToken)
+	}
 	if err != nil {
-		if err.Error() == "Unauthorized" {
+		if isUnauthorizedError(err) {
 			a.myLog("Unauthorized attempt! JWT's not valid!")
+			a.myLog(err)
 
-			a.unauthorizedHandler.ServeHTTP(w, r)
-			return errors.New("Unauthorized")
-		} else if err.Error() == "Server is not authorized to issue new tokens" {
-			a.unauthorizedHandler.ServeHTTP(w, r)
-			return errors.New("Unauthorized")
+			a.setBearerChallenge(w, bearerErrorInvalidToken, err.Error())
+			a.unauthorizedHandler(w, r, err)
+			return nil, err
 		} else {
-			// @adam-hanna: do we 401 or 500, here?
-			// it could be 401 bc the token they provided was messed up
-			// or it could be 500 bc there was some error on our end
+			// there was some error on our end
 			a.myLog(err)
-			a.errorHandler.ServeHTTP(w, r)
-			return errors.New("Internal Server Error")
+			a.errorHandler(w, r, err)
+			return nil, err
 		}
 	}
 
@@ -328,107 +518,114 @@ func (a *Auth) Process(w http.ResponseWriter, r *http.Request) error {
 	// And tokens have been refreshed if need-be
 	a.setAuthAndRefreshTokens(&w, authTokenString, refreshTokenString)
 	w.Header().Set("X-CSRF-Token", csrfSecret)
-	w.Header().Set("Auth-Expiry", strconv.FormatInt(time.Now().Add(a.options.AuthTokenValidTime).Unix(), 10))
-	w.Header().Set("Refresh-Expiry", strconv.FormatInt(time.Now().Add(a.options.RefreshTokenValidTime).Unix(), 10))
+	w.Header().Set("Auth-Expiry", strconv.FormatInt(a.authTokenExpiry(claims), 10))
+	if refreshTokenString != "" {
+		w.Header().Set("Refresh-Expiry", strconv.FormatInt(a.now().Add(a.options.RefreshTokenValidTime).Unix(), 10))
+	}
 
-	return nil
+	return r.WithContext(newContextWithClaims(r.Context(), claims)), nil
 }
 
+// NullifyTokens logs the request's session out: the tokens are cleared from the client, and the
+// refresh token is revoked along with its family (see revokeRequestTokens).
 // note @adam-hanna: this should return an error!
 func (a *Auth) NullifyTokens(w *http.ResponseWriter, r *http.Request) {
-	var refreshTokenValue string
-
-	if a.options.BearerTokens {
-		// tokens are not in cookies
-		if r.Header.Get("Content-Type") == "application/json" {
-			content, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				a.myLog("Err decoding bearer tokens json \n" + err.Error())
-				a.errorHandler.ServeHTTP(*w, r)
-				return
-			}
-			r.Body = ioutil.NopCloser(bytes.NewReader(content))
+	if !a.options.AuthorizationHeader && !a.options.BearerTokens {
+		http.SetCookie(*w, a.authCookie("", a.now().Add(-1000*time.Hour)))
+		http.SetCookie(*w, a.refreshCookie("", a.now().Add(-1000*time.Hour)))
+	}
 
-			var bearerTokens bearerTokensStruct
-			err = json.Unmarshal(content, &bearerTokens)
-			if err != nil {
-				a.myLog("Err decoding bearer tokens json \n" + err.Error())
-				a.errorHandler.ServeHTTP(*w, r)
-				return
-			}
-			refreshTokenValue = bearerTokens.Refresh_Token
-		} else {
-			r.ParseForm()
-			refreshTokenValue = strings.Join(r.Form["refresh_token"], "")
-		}
-	} else {
-		authCookie := http.Cookie{
-			Name:     "AuthToken",
-			Value:    "",
-			Expires:  time.Now().Add(-1000 * time.Hour),
-			HttpOnly: true,
-			Secure:   !a.options.IsDevEnv,
-		}
+	authTokenValue, refreshTokenValue, err := a.grabTokensFromReq(r)
+	if err != nil {
+		a.myLog("Err reading tokens \n" + err.Error())
+		a.errorHandler(*w, r, err)
+		return
+	}
 
-		http.SetCookie(*w, &authCookie)
+	// revoke the tokens in our db
+	if err := a.revokeRequestTokens(authTokenValue, refreshTokenValue); err != nil {
+		a.myLog("Err revoking tokens \n" + err.Error())
+	}
 
-		refreshCookie := http.Cookie{
-			Name:     "RefreshToken",
-			Value:    "",
-			Expires:  time.Now().Add(-1000 * time.Hour),
-			HttpOnly: true,
-			Secure:   !a.options.IsDevEnv,
-		}
+	setHeader(*w, "X-CSRF-Token", "")
+	setHeader(*w, "Auth-Expiry", strconv.FormatInt(a.now().Add(-1000*time.Hour).Unix(), 10))
+	setHeader(*w, "Refresh-Expiry", strconv.FormatInt(a.now().Add(-1000*time.Hour).Unix(), 10))
 
-		http.SetCookie(*w, &refreshCookie)
+	return
+}
 
-		// if present, revoke the refresh cookie from our db
-		RefreshCookie, refreshErr := r.Cookie("RefreshToken")
-		if refreshErr == http.ErrNoCookie {
-			// do nothing, there is no refresh cookie present
-			return
-		} else if refreshErr != nil {
-			a.myLog(refreshErr)
-			a.errorHandler.ServeHTTP(*w, r)
-			return
+// revokeRequestTokens revokes the refresh token that the request's tokens belong to, along with its
+// family and session. The auth token shares the refresh token's id and family, so it's used when
+// the refresh token wasn't sent, e.g. because its cookie is restricted to the refresh endpoint.
+func (a *Auth) revokeRequestTokens(authTokenValue string, refreshTokenValue string) error {
+	for _, tokenString := range []string{refreshTokenValue, authTokenValue} {
+		if tokenString == "" {
+			continue
 		}
-		refreshTokenValue = RefreshCookie.Value
-	}
 
-	a.revokeRefreshToken(refreshTokenValue)
+		// an expired token still identifies its family
+		claims, _, err := a.parseTokenType(tokenString)
+		if err != nil && err != ErrTokenExpired {
+			a.myLog("Token to revoke is not valid")
+			a.myLog(err)
+			continue
+		}
 
-	setHeader(*w, "X-CSRF-Token", "")
-	setHeader(*w, "Auth-Expiry", strconv.FormatInt(time.Now().Add(-1000*time.Hour).Unix(), 10))
-	setHeader(*w, "Refresh-Expiry", strconv.FormatInt(time.Now().Add(-1000*time.Hour).Unix(), 10))
+		return a.revokeTokenClaims(claims)
+	}
 
-	return
+	return nil
 }
 
 func (a *Auth) setAuthAndRefreshTokens(w *http.ResponseWriter, authTokenString string, refreshTokenString string) {
-	if a.options.BearerTokens {
+	if a.options.AuthorizationHeader {
+		// the client may not have sent a refresh token with this request
+		setHeader(*w, authTokenHeader, authTokenString)
+		if refreshTokenString != "" {
+			setHeader(*w, refreshTokenHeader, refreshTokenString)
+		}
+	} else if a.options.BearerTokens {
 		// tokens are not in cookies
 		setHeader(*w, "Auth_Token", authTokenString)
-		setHeader(*w, "Refresh_Token", refreshTokenString)
+		if refreshTokenString != "" {
+			setHeader(*w, "Refresh_Token", refreshTokenString)
+		}
 	} else {
 		// tokens are in cookies
-		authCookie := http.Cookie{
-			Name:     "AuthToken",
-			Value:    authTokenString,
-			Expires:  time.Now().Add(a.options.AuthTokenValidTime),
-			HttpOnly: true,
-			Secure:   !a.options.IsDevEnv,
+		http.SetCookie(*w, a.authCookie(authTokenString, a.now().Add(a.options.AuthTokenValidTime)))
+
+		// the refresh cookie may not have been sent with this request, e.g. if it's restricted
+		// to the refresh endpoint
+		if refreshTokenString != "" {
+			http.SetCookie(*w, a.refreshCookie(refreshTokenString, a.now().Add(a.options.RefreshTokenValidTime)))
 		}
-		http.SetCookie(*w, &authCookie)
-
-		refreshCookie := http.Cookie{
-			Name:     "RefreshToken",
-			Value:    refreshTokenString,
-			Expires:  time.Now().Add(a.options.RefreshTokenValidTime),
-			HttpOnly: true,
-			Secure:   !a.options.IsDevEnv,
+	}
+}
+
+// grabBearerTokenFromReq returns the token from an "Authorization: Bearer <token>" header
+func grabBearerTokenFromReq(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[len("Bearer "):])
+}
+
+// setBearerChallenge sets the WWW-Authenticate header when tokens are sent in the Authorization header
+func (a *Auth) setBearerChallenge(w http.ResponseWriter, errorCode string, description string) {
+	if !a.options.AuthorizationHeader {
+		return
+	}
+
+	challenge := "Bearer"
+	if errorCode != "" {
+		challenge += ` error="` + errorCode + `"`
+		if description != "" {
+			challenge += `, error_description="` + description + `"`
 		}
-		http.SetCookie(*w, &refreshCookie)
 	}
+	w.Header().Set("WWW-Authenticate", challenge)
 }
 
 func grabCsrfFromReq(r *http.Request) string {
@@ -450,301 +647,442 @@ func grabCsrfFromReq(r *http.Request) string {
 
 // and also modify create refresh and auth token functions!
 func (a *Auth) IssueNewTokens(w http.ResponseWriter, claims ClaimsType) error {
+	return a.IssueNewTokensWithClaims(w, &claims)
+}
+
+// IssueNewTokensWithClaims is like IssueNewTokens, but takes your own claims type (see SetClaimsFactory).
+// The claims are copied, so the caller's value isn't modified.
+func (a *Auth) IssueNewTokensWithClaims(w http.ResponseWriter, claims Claims) error {
+	return a.issueNewTokens(w, nil, claims)
+}
+
+// IssueNewSession is like IssueNewTokensWithClaims, but also records the request's user agent
+// and ip with the new session (see SetSessionStore)
+func (a *Auth) IssueNewSession(w http.ResponseWriter, r *http.Request, claims Claims) error {
+	return a.issueNewTokens(w, r, claims)
+}
+
+func (a *Auth) issueNewTokens(w http.ResponseWriter, r *http.Request, claims Claims) error {
+	authTokenString, refreshTokenString, csrfSecret, _, err := a.newTokens(r, claims)
+	if err != nil {
+		return err
+	}
+
+	a.setAuthAndRefreshTokens(&w, authTokenString, refreshTokenString)
+
+	w.Header().Set("X-CSRF-Token", csrfSecret)
+	w.Header().Set("Auth-Expiry", strconv.FormatInt(a.now().Add(a.options.AuthTokenValidTime).Unix(), 10))
+	w.Header().Set("Refresh-Expiry", strconv.FormatInt(a.now().Add(a.options.RefreshTokenValidTime).Unix(), 10))
+
+	return nil
+}
+
+// newTokens issues a new token family with a new csrf secret, and records its session.
+// The claims are copied; the copy, as issued, is returned.
+func (a *Auth) newTokens(r *http.Request, claims Claims) (authTokenString, refreshTokenString, csrfSecret string, newClaims Claims, err error) {
 	if a.options.VerifyOnlyServer {
 		a.myLog("Server is not authorized to issue new tokens")
-		return errors.New("Server is not authorized to issue new tokens")
+		err = ErrVerifyOnly
+		return
+	}
 
-	} else {
-		// generate the csrf secret
-		csrfSecret, err := randomstrings.GenerateRandomString(32)
-		if err != nil {
-			return err
-		}
+	newClaims, err = a.copyClaims(claims)
+	if err != nil {
+		return
+	}
+	base := newClaims.Base()
 
-		// generate the refresh token
-		refreshTokenString, err := a.createRefreshTokenString(claims, csrfSecret)
-		if err != nil {
-			return err
-		}
+	// generate the csrf secret
+	csrfSecret, err = randomstrings.GenerateRandomString(32)
+	if err != nil {
+		return
+	}
 
-		// generate the auth token
-		authTokenString, err := a.createAuthTokenString(claims, csrfSecret)
+	// give the refresh token an id, if the caller hasn't already
+	if base.StandardClaims.Id == "" {
+		base.StandardClaims.Id, err = randomstrings.GenerateRandomString(32)
 		if err != nil {
-			return err
+			return
 		}
+	}
 
-		a.setAuthAndRefreshTokens(&w, authTokenString, refreshTokenString)
+	// this is the first token in its family
+	if base.FamilyId == "" {
+		base.FamilyId = base.StandardClaims.Id
+	}
 
-		w.Header().Set("X-CSRF-Token", csrfSecret)
-		w.Header().Set("Auth-Expiry", strconv.FormatInt(time.Now().Add(a.options.AuthTokenValidTime).Unix(), 10))
-		w.Header().Set("Refresh-Expiry", strconv.FormatInt(time.Now().Add(a.options.RefreshTokenValidTime).Unix(), 10))
+	// the user is logging in now
+	if base.AuthTime == 0 {
+		base.AuthTime = a.now().Unix()
+	}
 
-		return nil
+	a.stampClaims(base)
+
+	// generate the refresh token
+	refreshTokenString, err = a.createRefreshTokenString(newClaims, csrfSecret)
+	if err != nil {
+		return
 	}
+
+	// generate the auth token
+	authTokenString, err = a.createAuthTokenString(newClaims, csrfSecret)
+	if err != nil {
+		return
+	}
+
+	err = a.recordSession(r, newClaims)
+	return
 }
 
 // @adam-hanna: check if refreshToken["sub"] == authToken["sub"]?
 // I don't think this is necessary bc a valid refresh token will always generate
 // a valid auth token of the same "sub"
-func (a *Auth) checkAndRefreshTokens(oldAuthTokenString string, oldRefreshTokenString string, oldCsrfSecret string) (newAuthTokenString, newRefreshTokenString, newCsrfSecret string, err error) {
+func (a *Auth) checkAndRefreshTokens(ctx context.Context, oldAuthTokenString string, oldRefreshTokenString string, oldCsrfSecret string) (newAuthTokenString, newRefreshTokenString, newCsrfSecret string, newClaims Claims, err error) {
+	// browsers never attach the Authorization header on their own, so requests that carry
+	// the auth token there can't be forged cross-site and don't need a csrf secret
+	checkCsrf := !a.options.AuthorizationHeader
+
 	// first, check that a csrf token was provided
-	if oldCsrfSecret == "" {
+	if checkCsrf && oldCsrfSecret == "" {
 		a.myLog("No CSRF token in request!")
-		err = errors.New("Unauthorized")
+		err = ErrNoCsrfToken
 		return
 	}
 
-	// now, check that it matches what's in the auth token claims
-	authToken, err := jwtGo.ParseWithClaims(oldAuthTokenString, &ClaimsType{}, func(token *jwtGo.Token) (interface{}, error) {
-		if token.Method != jwtGo.GetSigningMethod(a.options.SigningMethodString) {
-			a.myLog("Incorrect singing method on auth token")
-			return nil, errors.New("Incorrect singing method on auth token")
+	authTokenClaims, err := a.parseAuthToken(ctx, oldAuthTokenString)
+	if err != nil {
+		a.myLog("Auth token is not valid")
+		a.myLog(err)
+		// an expired auth token can still be refreshed, below
+		if err != ErrTokenExpired {
+			return
 		}
-		return a.verifyKey, nil
-	})
-
-	authTokenClaims, ok := authToken.Claims.(*ClaimsType)
-	if !ok {
-		return
 	}
-	if oldCsrfSecret != authTokenClaims.Csrf {
+
+	// now, check that the csrf token matches what's in the auth token claims
+	if checkCsrf && oldCsrfSecret != authTokenClaims.Base().Csrf {
 		a.myLog("CSRF token doesn't match jwt!")
-		err = errors.New("Unauthorized")
+		err = ErrCsrfMismatch
 		return
 	}
 
 	// next, check the auth token in a stateless manner
-	if authToken.Valid {
+	if err == nil {
 		a.myLog("Auth token is valid")
 		// auth token has not expired
 		// we need to return the csrf secret bc that's what the function calls for
-		newCsrfSecret = authTokenClaims.Csrf
+		newCsrfSecret = authTokenClaims.Base().Csrf
+		newClaims = authTokenClaims
 
 		// update the exp of refresh token string, but don't save to the db
 		// we don't need to check if our refresh token is valid here
 		// because we aren't renewing the auth token, the auth token is already valid
-		if !a.options.VerifyOnlyServer {
+		if !a.options.VerifyOnlyServer && oldRefreshTokenString != "" {
 			newRefreshTokenString, err = a.updateRefreshTokenExp(oldRefreshTokenString)
 		} else {
 			newRefreshTokenString = oldRefreshTokenString
 		}
 		newAuthTokenString = oldAuthTokenString
 		return
-	} else if ve, ok := err.(*jwtGo.ValidationError); ok {
-		a.myLog("Auth token is not valid")
-		if ve.Errors&(jwtGo.ValidationErrorExpired) != 0 {
-			if a.options.VerifyOnlyServer {
-				a.myLog("Server is not authorized to issue new tokens")
-				err = errors.New("Server is not authorized to issue new tokens")
-				return
-			} else {
-				a.myLog("Auth token is expired")
-				// auth token is expired
-				// fyi - refresh token is checked in the update auth func
-				newAuthTokenString, newCsrfSecret, err = a.updateAuthTokenString(oldRefreshTokenString, oldAuthTokenString)
-				if err != nil {
-					return
-				}
-
-				// update the exp of refresh token string
-				newRefreshTokenString, err = a.updateRefreshTokenExp(oldRefreshTokenString)
-				if err != nil {
-					return
-				}
-
-				// update the csrf string of the refresh token
-				newRefreshTokenString, err = a.updateRefreshTokenCsrf(newRefreshTokenString, newCsrfSecret)
-				return
-			}
-		} else {
-			a.myLog("Error in auth token")
-			err = errors.New("Error in auth token")
-			return
-		}
-	} else {
-		a.myLog("Error in auth token")
-		err = errors.New("Error in auth token")
+	}
+
+	if a.options.VerifyOnlyServer {
+		a.myLog("Server is not authorized to issue new tokens")
+		err = ErrVerifyOnly
+		return
+	}
+
+	a.myLog("Auth token is expired")
+	// the client needs to call the refresh endpoint
+	if a.options.RefreshEndpoint {
+		return
+	}
+
+	// auth token is expired
+	if a.options.RotateRefreshTokens {
+		newAuthTokenString, newRefreshTokenString, newCsrfSecret, newClaims, err = a.rotateRefreshToken(oldRefreshTokenString, nil)
+		return
+	}
+
+	// fyi - refresh token is checked in the update auth func
+	newAuthTokenString, newCsrfSecret, newClaims, err = a.updateAuthTokenString(oldRefreshTokenString, oldAuthTokenString)
+	if err != nil {
 		return
 	}
-}
 
-func (a *Auth) createRefreshTokenString(claims ClaimsType, csrfString string) (refreshTokenString string, err error) {
-	refreshTokenExp := time.Now().Add(a.options.RefreshTokenValidTime).Unix()
+	// update the exp of refresh token string
+	newRefreshTokenString, err = a.updateRefreshTokenExp(oldRefreshTokenString)
 	if err != nil {
 		return
 	}
 
-	claims.StandardClaims.ExpiresAt = refreshTokenExp
-	claims.Csrf = csrfString
+	// update the csrf string of the refresh token
+	newRefreshTokenString, err = a.updateRefreshTokenCsrf(newRefreshTokenString, newCsrfSecret)
+	return
+}
+
+// createRefreshTokenString sets the refresh token's exp and csrf on the given claims and signs them
+func (a *Auth) createRefreshTokenString(claims Claims, csrfString string) (refreshTokenString string, err error) {
+	now := a.now()
+	refreshTokenExp := now.Add(a.options.RefreshTokenValidTime).Unix()
+
+	base := claims.Base()
+	base.StandardClaims.ExpiresAt = refreshTokenExp
+	base.StandardClaims.IssuedAt = now.Unix()
+	base.StandardClaims.NotBefore = now.Unix()
+	base.Csrf = csrfString
 
 	// create a signer
 	refreshJwt := jwtGo.NewWithClaims(jwtGo.GetSigningMethod(a.options.SigningMethodString), claims)
+	refreshJwt.Header["typ"] = refreshTokenType
 
 	// generate the refresh token string
-	refreshTokenString, err = refreshJwt.SignedString(a.signKey)
+	refreshTokenString, err = a.signToken(refreshJwt)
+	if err != nil {
+		return
+	}
+
+	err = a.storeRefreshToken(base)
 	return
 }
 
-func (a *Auth) createAuthTokenString(claims ClaimsType, csrfSecret string) (authTokenString string, err error) {
-	authTokenExp := time.Now().Add(a.options.AuthTokenValidTime).Unix()
+// createAuthTokenString sets the auth token's exp and csrf on the given claims and signs them
+func (a *Auth) createAuthTokenString(claims Claims, csrfSecret string) (authTokenString string, err error) {
+	now := a.now()
+	authTokenExp := now.Add(a.options.AuthTokenValidTime).Unix()
 
-	claims.StandardClaims.ExpiresAt = authTokenExp
-	claims.Csrf = csrfSecret
+	base := claims.Base()
+	base.StandardClaims.ExpiresAt = authTokenExp
+	base.StandardClaims.IssuedAt = now.Unix()
+	base.StandardClaims.NotBefore = now.Unix()
+	base.Csrf = csrfSecret
 
 	// create a signer
 	authJwt := jwtGo.NewWithClaims(jwtGo.GetSigningMethod(a.options.SigningMethodString), claims)
+	authJwt.Header["typ"] = authTokenType
 
 	// generate the auth token string
-	authTokenString, err = authJwt.SignedString(a.signKey)
+	authTokenString, err = a.signToken(authJwt)
 	return
 }
 
 func (a *Auth) updateRefreshTokenExp(oldRefreshTokenString string) (string, error) {
-	refreshToken, _ := jwtGo.ParseWithClaims(oldRefreshTokenString, &ClaimsType{}, func(token *jwtGo.Token) (interface{}, error) {
-		// no need verify refresh token alg because it was verified at `updateAuthTokenString`
-		return a.verifyKey, nil
-	})
+	// the refresh token hasn't necessarily been verified yet (e.g. when the auth token is
+	// still valid), so verify it before re-signing it
+	oldRefreshTokenClaims, err := a.parseRefreshToken(oldRefreshTokenString)
+	if err != nil {
+		a.myLog("Refresh token is not valid!")
+		a.myLog(err)
+		return "", err
+	}
 
-	oldRefreshTokenClaims, ok := refreshToken.Claims.(*ClaimsType)
-	if !ok {
-		return "", errors.New("Error parsing claims")
+	// don't bring a revoked (or rotated) refresh token back to life in the store
+	if a.tokenStore != nil && !a.checkTokenId(oldRefreshTokenClaims.Base().StandardClaims.Id) {
+		a.myLog("Refresh token has been revoked!")
+		return "", ErrRefreshRevoked
 	}
 
-	refreshTokenExp := time.Now().Add(a.options.RefreshTokenValidTime).Unix()
-	oldRefreshTokenClaims.StandardClaims.ExpiresAt = refreshTokenExp
+	refreshTokenExp := a.now().Add(a.options.RefreshTokenValidTime).Unix()
+	oldRefreshTokenClaims.Base().StandardClaims.ExpiresAt = refreshTokenExp
 
 	// create a signer
 	refreshJwt := jwtGo.NewWithClaims(jwtGo.GetSigningMethod(a.options.SigningMethodString), oldRefreshTokenClaims)
+	refreshJwt.Header["typ"] = refreshTokenType
 
 	// generate the refresh token string
-	return refreshJwt.SignedString(a.signKey)
+	refreshTokenString, err := a.signToken(refreshJwt)
+	if err != nil {
+		return "", err
+	}
+
+	// the store needs to know about the new expiry, too
+	return refreshTokenString, a.storeRefreshToken(oldRefreshTokenClaims.Base())
 }
 
-func (a *Auth) updateAuthTokenString(refreshTokenString string, oldAuthTokenString string) (newAuthTokenString, csrfSecret string, err error) {
-	refreshToken, err := jwtGo.ParseWithClaims(refreshTokenString, &ClaimsType{}, func(token *jwtGo.Token) (interface{}, error) {
-		if token.Method != jwtGo.GetSigningMethod(a.options.SigningMethodString) {
-			a.myLog("Incorrect singing method on auth token")
-			return nil, errors.New("Incorrect singing method on auth token")
-		}
-		return a.verifyKey, nil
+// storeRefreshToken registers the refresh token with the token store, if one has been set
+func (a *Auth) storeRefreshToken(claims *ClaimsType) error {
+	if a.tokenStore == nil {
+		return nil
+	}
+
+	return a.tokenStore.StoreToken(StoredToken{
+		Id:        claims.StandardClaims.Id,
+		Subject:   claims.StandardClaims.Subject,
+		FamilyId:  claims.FamilyId,
+		ExpiresAt: time.Unix(claims.StandardClaims.ExpiresAt, 0),
 	})
-	// if err != nil {
-	// 	return
-	// }
+}
 
-	refreshTokenClaims, ok := refreshToken.Claims.(*ClaimsType)
-	if !ok {
-		err = errors.New("Error reading jwt claims")
+func (a *Auth) updateAuthTokenString(refreshTokenString string, oldAuthTokenString string) (newAuthTokenString, csrfSecret string, authTokenClaims Claims, err error) {
+	authTokenClaims, err = a.checkRefreshToken(refreshTokenString)
+	if err != nil {
+		return
+	}
+
+	// issue a new auth token
+	// our policy is to regenerate the csrf secret for each new auth token
+	csrfSecret, err = randomstrings.GenerateRandomString(32)
+	if err != nil {
 		return
 	}
 
-	// check if the refresh token has been revoked
-	if a.checkTokenId(refreshTokenClaims.StandardClaims.Id) {
-		a.myLog("Refresh token has not been revoked")
-		// the refresh token has not been revoked
-		// has it expired?
-		if refreshToken.Valid {
-			a.myLog("Refresh token is not expired")
-			// nope, the refresh token has not expired
-			// issue a new auth token
+	newAuthTokenString, err = a.createAuthTokenString(authTokenClaims, csrfSecret)
 
-			// our policy is to regenerate the csrf secret for each new auth token
-			csrfSecret, err = randomstrings.GenerateRandomString(32)
-			if err != nil {
-				return
-			}
+	// fyi - updating of refreshtoken csrf and exp is done after calling this func
+	// so we can simply return
+	return
+}
 
-			newAuthTokenString, err = a.createAuthTokenString(*refreshTokenClaims, csrfSecret)
+// rotateTokens exchanges a verified refresh token (see checkRefreshToken) for a new one with
+// a new id in the same family, and issues a new auth token and csrf secret. The claims are
+// updated in place. The old refresh token id isn't revoked here; the caller revokes it once the
+// new tokens can be handed out again (see rotateRefreshToken), so that any later use of it can be detected.
+func (a *Auth) rotateTokens(refreshTokenClaims Claims) (newAuthTokenString, newRefreshTokenString, csrfSecret string, err error) {
+	base := refreshTokenClaims.Base()
+	oldTokenId := base.StandardClaims.Id
 
-			// fyi - updating of refreshtoken csrf and exp is done after calling this func
-			// so we can simply return
-			return
-		} else {
-			a.myLog("Refresh token has expired!")
-			// the refresh token has expired! Require the user to re-authenticate
-			// @adam-hanna: Do we want to revoke the token in our db?
-			// I don't think we need to because it has expired and we can simply check the
-			// exp. No need to update the db.
+	csrfSecret, err = randomstrings.GenerateRandomString(32)
+	if err != nil {
+		return
+	}
 
-			err = errors.New("Unauthorized")
-			return
-		}
-	} else {
-		a.myLog("Refresh token has been revoked!")
-		// the refresh token has been revoked!
-		err = errors.New("Unauthorized")
+	base.StandardClaims.Id, err = randomstrings.GenerateRandomString(32)
+	if err != nil {
+		return
+	}
+	if base.FamilyId == "" {
+		// tokens issued before rotation was turned on start a new family
+		base.FamilyId = oldTokenId
+	}
+
+	newRefreshTokenString, err = a.createRefreshTokenString(refreshTokenClaims, csrfSecret)
+	if err != nil {
 		return
 	}
+
+	newAuthTokenString, err = a.createAuthTokenString(refreshTokenClaims, csrfSecret)
+	if err != nil {
+		return
+	}
+
+	a.myLog("Refresh token has been rotated")
+	return
 }
 
-func (a *Auth) updateRefreshTokenCsrf(oldRefreshTokenString string, newCsrfString string) (string, error) {
-	refreshToken, _ := jwtGo.ParseWithClaims(oldRefreshTokenString, &ClaimsType{}, func(token *jwtGo.Token) (interface{}, error) {
-		// no need verify refresh token alg because it was verified at `updateAuthTokenString`
-		return a.verifyKey, nil
-	})
+// checkRefreshToken verifies the refresh token and checks that it has not been revoked
+func (a *Auth) checkRefreshToken(refreshTokenString string) (Claims, error) {
+	refreshTokenClaims, err := a.verifyRefreshToken(refreshTokenString)
+	if err != nil {
+		return nil, err
+	}
 
-	oldRefreshTokenClaims, ok := refreshToken.Claims.(*ClaimsType)
-	if !ok {
+	if err := a.checkRefreshTokenId(refreshTokenClaims); err != nil {
+		return nil, err
+	}
+
+	return refreshTokenClaims, nil
+}
+
+// verifyRefreshToken checks the refresh token's signature and expiry, but not whether it has been revoked
+func (a *Auth) verifyRefreshToken(refreshTokenString string) (Claims, error) {
+	if refreshTokenString == "" {
+		a.myLog("No refresh token!")
+		return nil, ErrNoRefreshToken
+	}
+
+	// has the refresh token expired, or is the signature bad?
+	refreshTokenClaims, err := a.parseRefreshToken(refreshTokenString)
+	if err != nil {
+		a.myLog("Refresh token is not valid!")
+		// the refresh token has expired! Require the user to re-authenticate
+		// @adam-hanna: Do we want to revoke the token in our db?
+		// I don't think we need to because it has expired and we can simply check the
+		// exp. No need to update the db.
+		a.myLog(err)
+		return nil, err
+	}
+
+	return refreshTokenClaims, nil
+}
+
+// checkRefreshTokenId checks that the verified refresh token has not been revoked
+func (a *Auth) checkRefreshTokenId(refreshTokenClaims Claims) error {
+	base := refreshTokenClaims.Base()
+	if a.checkTokenId(base.StandardClaims.Id) {
+		return nil
+	}
+
+	a.myLog("Refresh token has been revoked!")
+	if a.options.RotateRefreshTokens && base.FamilyId != "" {
+		// a rotated refresh token is being used again, so it may have been stolen.
+		// Revoke every token in its family.
+		a.myLog("Refresh token reuse detected! Revoking token family")
+		if revokeErr := a.revokeTokenFamily(base.FamilyId); revokeErr != nil {
+			a.myLog(revokeErr)
+		}
+		return ErrRefreshReused
+	}
+	return ErrRefreshRevoked
+}
+
+func (a *Auth) updateRefreshTokenCsrf(oldRefreshTokenString string, newCsrfString string) (string, error) {
+	// no need to check the error; the refresh token was verified at `updateRefreshTokenExp`
+	oldRefreshTokenClaims, _ := a.parseRefreshToken(oldRefreshTokenString)
+	if oldRefreshTokenClaims == nil {
 		return "", errors.New("Error parsing claims")
 	}
 
-	oldRefreshTokenClaims.Csrf = newCsrfString
+	oldRefreshTokenClaims.Base().Csrf = newCsrfString
 
 	// create a signer
 	refreshJwt := jwtGo.NewWithClaims(jwtGo.GetSigningMethod(a.options.SigningMethodString), oldRefreshTokenClaims)
+	refreshJwt.Header["typ"] = refreshTokenType
 
 	// generate the refresh token string
-	return refreshJwt.SignedString(a.signKey)
+	return a.signToken(refreshJwt)
 }
 
+// GrabTokenClaims returns the claims of the request's verified auth token.
+// It only works on requests that have been passed through the middleware; prefer ClaimsFromContext.
 func (a *Auth) GrabTokenClaims(w http.ResponseWriter, r *http.Request) (ClaimsType, error) {
-	var authTokenValue string
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		a.myLog("No claims in request context")
+		return ClaimsType{}, ErrNoClaims
+	}
 
-	// read cookies
-	if a.options.BearerTokens {
-		// tokens are not in cookies
-		if r.Header.Get("Content-Type") == "application/json" {
-			content, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				a.myLog("Err decoding bearer tokens json \n" + err.Error())
-				return ClaimsType{}, errors.New("Internal Server Error")
-			}
-			r.Body = ioutil.NopCloser(bytes.NewReader(content))
+	return claims, nil
+}
 
-			var bearerTokens bearerTokensStruct
-			err = json.Unmarshal(content, &bearerTokens)
-			if err != nil {
-				a.myLog("Err decoding bearer tokens json \n" + err.Error())
-				return ClaimsType{}, errors.New("Internal Server Error")
-			}
-			authTokenValue = bearerTokens.Auth_Token
-		} else {
-			r.ParseForm()
-			authTokenValue = strings.Join(r.Form["Auth_Token"], "")
-		}
-	} else {
-		AuthCookie, authErr := r.Cookie("AuthToken")
-		if authErr == http.ErrNoCookie {
-			a.myLog("Unauthorized attempt! No auth cookie")
-			a.NullifyTokens(&w, r)
-			return ClaimsType{}, errors.New("Unauthorized")
-		} else if authErr != nil {
-			a.myLog(authErr)
-			a.NullifyTokens(&w, r)
-			return ClaimsType{}, errors.New("Unauthorized")
-		}
-		authTokenValue = AuthCookie.Value
+// keyFunc checks the token's signing method and returns the key to verify it with, by kid
+func (a *Auth) keyFunc(token *jwtGo.Token) (interface{}, error) {
+	if token.Method != jwtGo.GetSigningMethod(a.options.SigningMethodString) {
+		a.myLog("Incorrect singing method on token")
+		return nil, ErrInvalidSigningMethod
 	}
 
-	token, _ := jwtGo.ParseWithClaims(authTokenValue, &ClaimsType{}, func(token *jwtGo.Token) (interface{}, error) {
-		return ClaimsType{}, errors.New("Error processing token string claims")
-	})
-	tokenClaims, ok := token.Claims.(*ClaimsType)
+	// tokens issued before keys were rotated won't have a kid; use the active key
+	kid, _ := token.Header["kid"].(string)
+	verifyKey, ok := a.keys.verifyKey(kid)
+	if a.remoteKeys != nil {
+		// fetch the keys if our copy has expired, or if the kid is new to us
+		if err := a.remoteKeys.update(a.keys, a.options.SigningMethodString, !ok); err != nil {
+			a.myLog(err)
+		}
+		verifyKey, ok = a.keys.verifyKey(kid)
+	}
 	if !ok {
-		return ClaimsType{}, errors.New("Error processing token string claims")
+		a.myLog("Unknown kid on token: " + kid)
+		return nil, ErrUnknownKeyId
 	}
 
-	return *tokenClaims, nil
+	// never verify with a key of another type than the signing method's, e.g. an RSA public key
+	// as an HMAC secret
+	if err := checkKeyTypes(a.options.SigningMethodString, nil, verifyKey); err != nil {
+		a.myLog("Incorrect key type for the signing method")
+		return nil, ErrInvalidSigningMethod
+	}
+	return verifyKey, nil
 }
 
 func (a *Auth) myLog(stoofs interface{}) {
@@ -753,6 +1091,15 @@ func (a *Auth) myLog(stoofs interface{}) {
 	}
 }
 
+// authTokenExpiry returns the auth token's exp, in unix time. The auth token isn't reissued on every
+// request, so this is more accurate than now + AuthTokenValidTime.
+func (a *Auth) authTokenExpiry(claims Claims) int64 {
+	if exp := claims.Base().ExpiresAt; exp != 0 {
+		return exp
+	}
+	return a.now().Add(a.options.AuthTokenValidTime).Unix()
+}
+
 func setHeader(w http.ResponseWriter, header string, value string) {
 	w.Header().Set(header, value)
 }
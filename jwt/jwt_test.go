@@ -0,0 +1,432 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+func TestAuthorizationHeaderResponseHeaders(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{AuthorizationHeader: true})
+	tokens := h.Issue(bob)
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens)))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+
+	// the same names that the refresh token is read from, without underscores
+	if w.Header().Get("Auth-Token") == "" || w.Header().Get("Refresh-Token") == "" {
+		t.Errorf("headers = %v", w.Header())
+	}
+	for name := range w.Header() {
+		if strings.Contains(name, "_") {
+			t.Errorf("header %s has an underscore", name)
+		}
+	}
+
+	refreshed := h.Update(tokens, w)
+	if refreshed.AuthToken == tokens.AuthToken {
+		t.Fatal("auth token wasn't refreshed")
+	}
+	if w = h.Serve(okHandler, h.NewRequest("GET", "/", refreshed)); w.Code != 200 {
+		t.Errorf("refreshed tokens code = %d", w.Code)
+	}
+}
+
+func TestBearerTokensResponseHeaders(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{BearerTokens: true})
+	tokens := h.Issue(bob)
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens)))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	if w.Header().Get("Auth_Token") == "" || w.Header().Get("Refresh_Token") == "" {
+		t.Errorf("headers = %v", w.Header())
+	}
+}
+
+func TestAuthorizationHeaderChallenge(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{AuthorizationHeader: true})
+	tokens := h.Issue(bob)
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", h.Tamper(tokens)))
+	if w.Code != 401 {
+		t.Fatalf("code = %d, want 401", w.Code)
+	}
+	if challenge := w.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, `error="invalid_token"`) {
+		t.Errorf("WWW-Authenticate = %q", challenge)
+	}
+}
+
+// opaqueSigner hides the key's type, like a key in an HSM would
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+// newSigningAuth is an Auth that signs with the key, and stamps tokens with the kid "key"
+func newSigningAuth(t *testing.T, signingMethod string, signKey crypto.Signer) *jwt.Auth {
+	t.Helper()
+
+	var auth jwt.Auth
+	err := jwt.New(&auth, jwt.Options{
+		SigningMethodString: signingMethod,
+		SigningKey:          signKey,
+		KeyId:               "key",
+		AuthorizationHeader: true,
+		IsDevEnv:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &auth
+}
+
+func generateKeys(t *testing.T) (rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey, edKey ed25519.PrivateKey) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsaKey, ecKey, edKey
+}
+
+func TestSigningMethods(t *testing.T) {
+	rsaKey, ecKey, edKey := generateKeys(t)
+
+	for _, test := range []struct {
+		method string
+		key    crypto.Signer
+	}{
+		{"RS256", rsaKey},
+		{"PS256", rsaKey},
+		{"PS512", rsaKey},
+		{"ES384", ecKey},
+		{"EdDSA", edKey},
+		{"RS256", opaqueSigner{rsaKey}},
+		{"PS256", opaqueSigner{rsaKey}},
+		{"ES384", opaqueSigner{ecKey}},
+		{"EdDSA", opaqueSigner{edKey}},
+	} {
+		auth := newSigningAuth(t, test.method, test.key)
+		if !verifies(auth, issuedRequest(t, auth)) {
+			t.Errorf("%s with a %T: the token doesn't verify", test.method, test.key)
+		}
+	}
+}
+
+func TestPS256RejectsRS256(t *testing.T) {
+	rsaKey, _, _ := generateKeys(t)
+	ps256 := newSigningAuth(t, "PS256", rsaKey)
+	rs256 := newSigningAuth(t, "RS256", rsaKey)
+
+	// the same key and kid, but the wrong alg
+	if verifies(ps256, issuedRequest(t, rs256)) {
+		t.Error("a PS256 server accepted an RS256 token")
+	}
+	if verifies(rs256, issuedRequest(t, ps256)) {
+		t.Error("an RS256 server accepted a PS256 token")
+	}
+}
+
+func TestEdDSARejectsOtherKeysAndAlgs(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eddsa := newSigningAuth(t, "EdDSA", edKey)
+
+	if verifies(eddsa, issuedRequest(t, newSigningAuth(t, "EdDSA", otherKey))) {
+		t.Error("a token signed with another key was accepted")
+	}
+
+	// a token that's HMAC signed with the public key, as if it were a secret
+	var hs256 jwt.Auth
+	err = jwt.New(&hs256, jwt.Options{
+		SigningMethodString: "HS256",
+		HMACKey:             []byte(edKey.Public().(ed25519.PublicKey)),
+		KeyId:               "key",
+		AuthorizationHeader: true,
+		IsDevEnv:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifies(eddsa, issuedRequest(t, &hs256)) {
+		t.Error("an HS256 token was accepted")
+	}
+
+	// and an unsigned one
+	r := issuedRequest(t, eddsa)
+	parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+	parts[0] = jwtGo.EncodeSegment([]byte(`{"alg":"none","kid":"key","typ":"at+jwt"}`))
+	r.Header.Set("Authorization", "Bearer "+parts[0]+"."+parts[1]+".")
+	if verifies(eddsa, r) {
+		t.Error("an unsigned token was accepted")
+	}
+}
+
+func TestClaimsReachHandler(t *testing.T) {
+	for mode, options := range transportModes {
+		h := jwttest.New(t, options)
+		tokens := h.Issue(bob)
+
+		var called int
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called++
+			claims, ok := jwt.ClaimsFromContext(r.Context())
+			if !ok || claims.Subject != "bob" {
+				t.Errorf("%s: claims = %+v, %v", mode, claims, ok)
+			}
+			// the claims are from the token that is in use, i.e. the refreshed one
+			if claims.ExpiresAt <= h.Clock.Now().Unix() {
+				t.Errorf("%s: claims of an expired token", mode)
+			}
+			if grabbed, err := h.Auth.GrabTokenClaims(w, r); err != nil || grabbed.Id != claims.Id {
+				t.Errorf("%s: GrabTokenClaims = %+v, %v", mode, grabbed, err)
+			}
+		})
+
+		for _, serve := range []func(w http.ResponseWriter, r *http.Request){
+			h.Auth.Handler(handler).ServeHTTP,
+			func(w http.ResponseWriter, r *http.Request) {
+				h.Auth.HandlerFuncWithNext(w, r, handler)
+			},
+			// Process updates the request in place
+			func(w http.ResponseWriter, r *http.Request) {
+				if err := h.Auth.Process(w, r); err == nil {
+					handler(w, r)
+				}
+			},
+		} {
+			serve(httptest.NewRecorder(), h.NewRequest("GET", "/", tokens))
+			serve(httptest.NewRecorder(), h.NewRequest("GET", "/", h.ExpireAuthToken(tokens)))
+			serve(httptest.NewRecorder(), h.NewRequest("GET", "/", h.Tamper(tokens)))
+		}
+		if called != 6 {
+			t.Errorf("%s: the handler was called %d times, want 6", mode, called)
+		}
+	}
+
+	// there are no claims without the middleware
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, ok := jwt.ClaimsFromContext(r.Context()); ok {
+		t.Error("claims in a request that wasn't processed")
+	}
+	h := jwttest.New(t)
+	if _, err := h.Auth.GrabTokenClaims(httptest.NewRecorder(), r); err != jwt.ErrNoClaims {
+		t.Errorf("GrabTokenClaims error = %v, want ErrNoClaims", err)
+	}
+}
+
+func TestErrorHandlersReceiveError(t *testing.T) {
+	h := jwttest.New(t)
+	tokens := h.Issue(bob)
+
+	// a server that can only verify the tokens
+	options := h.Options
+	options.VerifyOnlyServer = true
+	var verifier jwt.Auth
+	if err := jwt.New(&verifier, options); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked := h.Issue(bob)
+	h.Revoke(revoked)
+	noCsrf, wrongCsrf := tokens, tokens
+	noCsrf.CsrfSecret = ""
+	wrongCsrf.CsrfSecret = "wrong"
+	badJSON := httptest.NewRequest("POST", "/", strings.NewReader("{"))
+	badJSON.Header.Set("Content-Type", "application/json")
+
+	for name, test := range map[string]struct {
+		auth   *jwt.Auth
+		r      *http.Request
+		status int
+		want   error
+	}{
+		"no tokens":         {h.Auth, h.NewRequest("GET", "/", jwttest.Tokens{}), 401, jwt.ErrNoAuthToken},
+		"no csrf token":     {h.Auth, h.NewRequest("GET", "/", noCsrf), 401, jwt.ErrNoCsrfToken},
+		"wrong csrf token":  {h.Auth, h.NewRequest("GET", "/", wrongCsrf), 401, jwt.ErrCsrfMismatch},
+		"tampered":          {h.Auth, h.NewRequest("GET", "/", h.Tamper(tokens)), 401, jwt.ErrBadSignature},
+		"revoked":           {h.Auth, h.NewRequest("GET", "/", h.ExpireAuthToken(revoked)), 401, jwt.ErrRefreshRevoked},
+		"verify only":       {&verifier, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens)), 401, jwt.ErrVerifyOnly},
+		"verified":          {&verifier, h.NewRequest("GET", "/", tokens), 200, nil},
+		"bearer token json": {nil, badJSON, 500, nil},
+	} {
+		auth := test.auth
+		if auth == nil {
+			bearer := jwttest.New(t, jwt.Options{BearerTokens: true})
+			auth = bearer.Auth
+		}
+
+		var got error
+		auth.SetUnauthorizedHandlerWithError(func(w http.ResponseWriter, r *http.Request, err error) {
+			got = err
+			w.WriteHeader(401)
+		})
+		auth.SetErrorHandlerWithError(func(w http.ResponseWriter, r *http.Request, err error) {
+			got = err
+			w.WriteHeader(500)
+		})
+
+		w := httptest.NewRecorder()
+		auth.Handler(okHandler).ServeHTTP(w, test.r)
+		if w.Code != test.status {
+			t.Errorf("%s: code = %d, want %d", name, w.Code, test.status)
+		}
+		if test.want != nil && got != test.want {
+			t.Errorf("%s: error = %v, want %v", name, got, test.want)
+		}
+		// e.g. a json syntax error, which isn't one of ours
+		if test.status == 500 && got == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// handlers that don't take the error still work
+	h.Auth.SetUnauthorizedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(418)
+	}))
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", h.Tamper(tokens))); w.Code != 418 {
+		t.Errorf("unauthorized handler code = %d", w.Code)
+	}
+}
+
+// responseCookies returns the cookies that the response sets, by name
+func responseCookies(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func TestHostPrefixCookies(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{CookieOptions: jwt.CookieOptions{Prefix: jwt.CookiePrefixHost, SameSite: http.SameSiteStrictMode}})
+
+	w := httptest.NewRecorder()
+	if err := h.Auth.IssueNewTokens(w, bob); err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(w)
+	for _, name := range []string{"__Host-AuthToken", "__Host-RefreshToken"} {
+		cookie := cookies[name]
+		if cookie == nil {
+			t.Fatalf("no %s cookie in %v", name, cookies)
+		}
+		// __Host- cookies must be Secure, have a Path of "/" and no Domain
+		if !cookie.Secure || cookie.Path != "/" || cookie.Domain != "" || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("%s cookie = %+v", name, cookie)
+		}
+	}
+
+	// the prefixed cookies are read back, and cleared with the same attributes
+	tokens := h.Update(jwttest.Tokens{}, w)
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens)); w.Code != 200 {
+		t.Errorf("code = %d", w.Code)
+	}
+	w = h.Serve(logoutHandler(h), h.NewRequest("POST", "/logout", tokens))
+	for name, cookie := range responseCookies(w) {
+		if cookie.Value != "" || !cookie.Secure || cookie.Path != "/" {
+			t.Errorf("cleared %s cookie = %+v", name, cookie)
+		}
+	}
+	h.AssertTokensCleared(w)
+}
+
+func TestCookieOptionsAreChecked(t *testing.T) {
+	for name, options := range map[string]jwt.CookieOptions{
+		"__Host- with a domain":       {Prefix: jwt.CookiePrefixHost, Domain: "example.com"},
+		"__Host- with a path":         {Prefix: jwt.CookiePrefixHost, Path: "/api"},
+		"__Host- with a refresh path": {Prefix: jwt.CookiePrefixHost, RefreshTokenPath: "/refresh"},
+		"unknown prefix":              {Prefix: "__Insecure-"},
+		"same names":                  {AuthTokenName: "Token", RefreshTokenName: "Token"},
+	} {
+		var auth jwt.Auth
+		if err := jwt.New(&auth, jwt.Options{SigningMethodString: "HS256", HMACKey: []byte("secret"), CookieOptions: options}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// prefixed cookies must be Secure
+	for _, prefix := range []string{jwt.CookiePrefixHost, jwt.CookiePrefixSecure} {
+		var auth jwt.Auth
+		err := jwt.New(&auth, jwt.Options{SigningMethodString: "HS256", HMACKey: []byte("secret"), IsDevEnv: true, CookieOptions: jwt.CookieOptions{Prefix: prefix}})
+		if err == nil {
+			t.Errorf("%s cookies in dev mode: no error", prefix)
+		}
+	}
+}
+
+func TestRestrictedRefreshCookie(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{CookieOptions: jwt.CookieOptions{
+		Path:             "/",
+		RefreshTokenPath: "/auth/refresh",
+		SameSite:         http.SameSiteLaxMode,
+	}})
+
+	w := httptest.NewRecorder()
+	if err := h.Auth.IssueNewTokens(w, bob); err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(w)
+	if cookies["AuthToken"].Path != "/" || cookies["RefreshToken"].Path != "/auth/refresh" {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	if cookies["AuthToken"].SameSite != http.SameSiteLaxMode || cookies["RefreshToken"].SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookies = %v", cookies)
+	}
+	tokens := h.Update(jwttest.Tokens{}, w)
+
+	// the browser doesn't send the refresh cookie to other paths, which is fine
+	authOnly := tokens
+	authOnly.RefreshToken = ""
+	w = h.Serve(okHandler, h.NewRequest("GET", "/api", authOnly))
+	if w.Code != 200 {
+		t.Errorf("code = %d", w.Code)
+	}
+
+	// without the refresh cookie, an expired auth token can't be refreshed, but the refresh
+	// cookie isn't cleared, so that the client can still use it at the refresh path
+	w = h.Serve(okHandler, h.NewRequest("GET", "/api", h.ExpireAuthToken(authOnly)))
+	if w.Code != 401 {
+		t.Errorf("expired auth token code = %d", w.Code)
+	}
+	if _, cleared := responseCookies(w)["RefreshToken"]; cleared {
+		t.Error("the refresh cookie was cleared")
+	}
+
+	w = refresh(h, h.ExpireAuthToken(tokens))
+	if w.Code != 200 {
+		t.Fatalf("refresh code = %d", w.Code)
+	}
+	if cookie := responseCookies(w)["RefreshToken"]; cookie == nil || cookie.Path != "/auth/refresh" {
+		t.Errorf("refreshed refresh cookie = %+v", cookie)
+	}
+}
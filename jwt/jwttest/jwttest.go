@@ -0,0 +1,440 @@
+// Package jwttest helps test handlers that are protected by a jwt.Auth middleware,
+// without key files, a running server, or going through the login flow by hand.
+//
+//	func TestRestricted(t *testing.T) {
+//		h := jwttest.New(t)
+//		tokens := h.Issue(jwt.ClaimsType{CustomClaims: map[string]interface{}{"Role": "admin"}})
+//
+//		w := h.Serve(restrictedHandler, h.NewRequest("GET", "/restricted", tokens))
+//		if w.Code != 200 {
+//			t.Fatal(w.Code)
+//		}
+//		h.AssertExpiryHeaders(w)
+//	}
+package jwttest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+const defaultKeyId = "jwttest"
+
+// Harness is an Auth with an ephemeral key, an in memory token and session store, and a fake clock
+type Harness struct {
+	Auth    *jwt.Auth
+	Store   *jwt.MemoryTokenStore
+	Clock   *FakeClock
+	Options jwt.Options
+
+	t             testing.TB
+	key           []byte
+	authCookie    string
+	refreshCookie string
+}
+
+// Tokens are the credentials that a client holds
+type Tokens struct {
+	AuthToken    string
+	RefreshToken string
+	CsrfSecret   string
+}
+
+// New builds a Harness. Any options are passed on to jwt.New, but the signing method and key are
+// always a random HMAC key, and IsDevEnv is true unless a cookie prefix is set.
+// The clock is a FakeClock, which starts at the current time unless one is passed in the options.
+func New(t testing.TB, options ...jwt.Options) *Harness {
+	t.Helper()
+
+	var o jwt.Options
+	if len(options) > 0 {
+		o = options[0]
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	o.SigningMethodString = "HS256"
+	o.HMACKey = key
+	o.PrivateKeyLocation = ""
+	o.PublicKeyLocation = ""
+	o.VerifyOnlyServer = false
+	o.JWKSURL = ""
+	o.RefreshURL = ""
+	if o.KeyId == "" {
+		o.KeyId = defaultKeyId
+	}
+	if o.CookieOptions.Prefix == "" {
+		o.IsDevEnv = true
+	}
+	if o.CookieOptions.AuthTokenName == "" {
+		o.CookieOptions.AuthTokenName = "AuthToken"
+	}
+	if o.CookieOptions.RefreshTokenName == "" {
+		o.CookieOptions.RefreshTokenName = "RefreshToken"
+	}
+	clock, ok := o.Clock.(*FakeClock)
+	if !ok {
+		clock = NewFakeClock(time.Now())
+	}
+	o.Clock = clock
+
+	h := &Harness{
+		Auth:          &jwt.Auth{},
+		Store:         jwt.NewMemoryTokenStore(time.Minute),
+		Clock:         clock,
+		Options:       o,
+		t:             t,
+		key:           key,
+		authCookie:    o.CookieOptions.Prefix + o.CookieOptions.AuthTokenName,
+		refreshCookie: o.CookieOptions.Prefix + o.CookieOptions.RefreshTokenName,
+	}
+	t.Cleanup(h.Store.Close)
+
+	if err := jwt.New(h.Auth, o); err != nil {
+		t.Fatal(err)
+	}
+	h.Auth.SetTokenStore(h.Store)
+	h.Auth.SetSessionStore(h.Store)
+
+	return h
+}
+
+// Issue issues new tokens with the claims, as your login handler would
+func (h *Harness) Issue(claims jwt.ClaimsType) Tokens {
+	h.t.Helper()
+	return h.IssueWithClaims(&claims)
+}
+
+// IssueWithClaims is like Issue, but takes your own claims type (see jwt.Auth.SetClaimsFactory)
+func (h *Harness) IssueWithClaims(claims jwt.Claims) Tokens {
+	h.t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := h.Auth.IssueNewTokensWithClaims(w, claims); err != nil {
+		h.t.Fatal(err)
+	}
+
+	return h.Update(Tokens{}, w)
+}
+
+// ExpireAuthToken returns the tokens with an expired auth token, so that the next request has to
+// refresh it
+func (h *Harness) ExpireAuthToken(tokens Tokens) Tokens {
+	h.t.Helper()
+	tokens.AuthToken = h.expire(tokens.AuthToken)
+	return tokens
+}
+
+// ExpireRefreshToken returns the tokens with an expired refresh token
+func (h *Harness) ExpireRefreshToken(tokens Tokens) Tokens {
+	h.t.Helper()
+	tokens.RefreshToken = h.expire(tokens.RefreshToken)
+	return tokens
+}
+
+// Revoke revokes the refresh token in the harness' store
+func (h *Harness) Revoke(tokens Tokens) {
+	h.t.Helper()
+
+	claims := h.claims(tokens.RefreshToken)
+	jti, _ := claims["jti"].(string)
+	if err := h.Store.RevokeToken(jti); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// Tamper returns the tokens with an auth token whose claims have been changed (the subject is
+// set to "tampered") without re-signing it, so its signature is invalid
+func (h *Harness) Tamper(tokens Tokens) Tokens {
+	h.t.Helper()
+
+	parts := strings.Split(tokens.AuthToken, ".")
+	if len(parts) != 3 {
+		h.t.Fatal("jwttest: malformed auth token")
+	}
+
+	claims := h.claims(tokens.AuthToken)
+	claims["sub"] = "tampered"
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+
+	tokens.AuthToken = strings.Join(parts, ".")
+	return tokens
+}
+
+// NewRequest returns an httptest request that carries the tokens
+func (h *Harness) NewRequest(method string, target string, tokens Tokens) *http.Request {
+	return h.Authorize(httptest.NewRequest(method, target, nil), tokens)
+}
+
+// Authorize adds the tokens and csrf secret to the request, wherever the middleware expects them.
+// With BearerTokens, they are added to the url's query, which the middleware reads as form values.
+// The refresh token isn't sent if RefreshEndpoint is set, just like a browser wouldn't.
+func (h *Harness) Authorize(r *http.Request, tokens Tokens) *http.Request {
+	sendRefreshToken := tokens.RefreshToken != "" && !h.Options.RefreshEndpoint
+
+	if tokens.CsrfSecret != "" {
+		r.Header.Set("X-CSRF-Token", tokens.CsrfSecret)
+	}
+
+	if h.Options.AuthorizationHeader {
+		if tokens.AuthToken != "" {
+			r.Header.Set("Authorization", "Bearer "+tokens.AuthToken)
+		}
+		if sendRefreshToken {
+			r.Header.Set("Refresh-Token", tokens.RefreshToken)
+		}
+
+	} else if h.Options.BearerTokens {
+		query := r.URL.Query()
+		query.Set("Auth_Token", tokens.AuthToken)
+		if sendRefreshToken {
+			query.Set("Refresh_Token", tokens.RefreshToken)
+		}
+		r.URL.RawQuery = query.Encode()
+
+	} else {
+		if tokens.AuthToken != "" {
+			r.AddCookie(&http.Cookie{Name: h.authCookie, Value: tokens.AuthToken})
+		}
+		if sendRefreshToken {
+			r.AddCookie(&http.Cookie{Name: h.refreshCookie, Value: tokens.RefreshToken})
+		}
+	}
+
+	return r
+}
+
+// NewRefreshRequest returns a request for the Auth's RefreshHandler that carries the refresh token
+func (h *Harness) NewRefreshRequest(tokens Tokens) *http.Request {
+	r := httptest.NewRequest("POST", "/refresh", nil)
+	if tokens.CsrfSecret != "" {
+		r.Header.Set("X-CSRF-Token", tokens.CsrfSecret)
+	}
+
+	if h.Options.AuthorizationHeader {
+		r.Header.Set("Refresh-Token", tokens.RefreshToken)
+	} else if h.Options.BearerTokens {
+		form := url.Values{"Refresh_Token": {tokens.RefreshToken}}
+		r = httptest.NewRequest("POST", "/refresh", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-CSRF-Token", tokens.CsrfSecret)
+	} else {
+		r.AddCookie(&http.Cookie{Name: h.refreshCookie, Value: tokens.RefreshToken})
+	}
+
+	return r
+}
+
+// Serve runs the request through the middleware and the handler
+func (h *Harness) Serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.Auth.Handler(handler).ServeHTTP(w, r)
+	return w
+}
+
+// Update returns the tokens that a client would hold after the response, i.e. the tokens with any
+// tokens and csrf secret that the response sets
+func (h *Harness) Update(tokens Tokens, w *httptest.ResponseRecorder) Tokens {
+	if h.Options.AuthorizationHeader {
+		if value := w.Header().Get("Auth-Token"); value != "" {
+			tokens.AuthToken = value
+		}
+		if value := w.Header().Get("Refresh-Token"); value != "" {
+			tokens.RefreshToken = value
+		}
+	} else if h.Options.BearerTokens {
+		if value := w.Header().Get("Auth_Token"); value != "" {
+			tokens.AuthToken = value
+		}
+		if value := w.Header().Get("Refresh_Token"); value != "" {
+			tokens.RefreshToken = value
+		}
+	} else {
+		if cookie := h.cookie(w, h.authCookie); cookie != nil {
+			tokens.AuthToken = cookie.Value
+		}
+		if cookie := h.cookie(w, h.refreshCookie); cookie != nil {
+			tokens.RefreshToken = cookie.Value
+		}
+	}
+
+	if _, ok := w.Header()["X-Csrf-Token"]; ok {
+		tokens.CsrfSecret = w.Header().Get("X-CSRF-Token")
+	}
+
+	return tokens
+}
+
+// AssertTokensSet fails the test unless the response sets an auth token, a refresh token and a
+// csrf secret. It returns them.
+func (h *Harness) AssertTokensSet(w *httptest.ResponseRecorder) Tokens {
+	h.t.Helper()
+
+	tokens := h.Update(Tokens{}, w)
+	if tokens.AuthToken == "" {
+		h.t.Error("jwttest: the response doesn't set an auth token")
+	}
+	if tokens.RefreshToken == "" {
+		h.t.Error("jwttest: the response doesn't set a refresh token")
+	}
+	if tokens.CsrfSecret == "" {
+		h.t.Error("jwttest: the response doesn't set a csrf secret")
+	}
+
+	if !h.Options.AuthorizationHeader && !h.Options.BearerTokens {
+		for _, name := range []string{h.authCookie, h.refreshCookie} {
+			cookie := h.cookie(w, name)
+			if cookie == nil {
+				continue
+			}
+			if !cookie.HttpOnly {
+				h.t.Errorf("jwttest: the %s cookie isn't HttpOnly", name)
+			}
+			if cookie.Secure == h.Options.IsDevEnv {
+				h.t.Errorf("jwttest: the %s cookie's Secure attribute is %v", name, cookie.Secure)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// AssertTokensCleared fails the test unless the response nullifies the tokens, e.g. on logout
+func (h *Harness) AssertTokensCleared(w *httptest.ResponseRecorder) {
+	h.t.Helper()
+
+	if csrf, ok := w.Header()["X-Csrf-Token"]; !ok || strings.Join(csrf, "") != "" {
+		h.t.Error("jwttest: the response doesn't clear the csrf secret")
+	}
+
+	if h.Options.AuthorizationHeader || h.Options.BearerTokens {
+		return
+	}
+
+	for _, name := range []string{h.authCookie, h.refreshCookie} {
+		cookie := h.cookie(w, name)
+		if cookie == nil {
+			h.t.Errorf("jwttest: the response doesn't clear the %s cookie", name)
+		} else if cookie.Value != "" || cookie.Expires.After(h.Clock.Now()) {
+			h.t.Errorf("jwttest: the %s cookie hasn't been cleared", name)
+		}
+	}
+}
+
+// AssertExpiryHeaders fails the test unless the response's Auth-Expiry header is in the future,
+// and no later than AuthTokenValidTime from now. The same goes for Refresh-Expiry, if it's set.
+func (h *Harness) AssertExpiryHeaders(w *httptest.ResponseRecorder) {
+	h.t.Helper()
+
+	authTokenValidTime := h.Options.AuthTokenValidTime
+	if authTokenValidTime <= 0 {
+		authTokenValidTime = 15 * time.Minute
+	}
+	refreshTokenValidTime := h.Options.RefreshTokenValidTime
+	if refreshTokenValidTime <= 0 {
+		refreshTokenValidTime = 72 * time.Hour
+	}
+
+	h.assertExpiry(w, "Auth-Expiry", authTokenValidTime, true)
+	h.assertExpiry(w, "Refresh-Expiry", refreshTokenValidTime, false)
+}
+
+func (h *Harness) assertExpiry(w *httptest.ResponseRecorder, header string, validTime time.Duration, required bool) {
+	h.t.Helper()
+
+	value := w.Header().Get(header)
+	if value == "" {
+		if required {
+			h.t.Errorf("jwttest: the response has no %s header", header)
+		}
+		return
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		h.t.Errorf("jwttest: the %s header isn't in unix time: %q", header, value)
+		return
+	}
+
+	// the expiry headers are only accurate to the second
+	exp := time.Unix(unix, 0)
+	now := h.Clock.Now()
+	if !exp.After(now.Add(-time.Second)) {
+		h.t.Errorf("jwttest: the %s header is in the past: %v", header, exp)
+	} else if exp.After(now.Add(validTime + time.Second)) {
+		h.t.Errorf("jwttest: the %s header is more than %v from now: %v", header, validTime, exp)
+	}
+}
+
+func (h *Harness) cookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	var found *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		// the last one wins, as in a browser
+		if cookie.Name == name {
+			found = cookie
+		}
+	}
+	return found
+}
+
+// claims verifies the token and returns its claims, without validating them
+func (h *Harness) claims(tokenString string) jwtGo.MapClaims {
+	h.t.Helper()
+	return h.parse(tokenString).Claims.(jwtGo.MapClaims)
+}
+
+// parse verifies the token, without validating its claims
+func (h *Harness) parse(tokenString string) *jwtGo.Token {
+	h.t.Helper()
+
+	parser := jwtGo.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, jwtGo.MapClaims{}, func(token *jwtGo.Token) (interface{}, error) {
+		if token.Method != jwtGo.SigningMethodHS256 {
+			return nil, jwt.ErrInvalidSigningMethod
+		}
+		return h.key, nil
+	})
+	if err != nil {
+		h.t.Fatal("jwttest: ", err)
+	}
+
+	return token
+}
+
+// expire re-signs the token with an exp in the past. Its headers, e.g. its typ, are kept.
+func (h *Harness) expire(tokenString string) string {
+	h.t.Helper()
+
+	parsed := h.parse(tokenString)
+	claims := parsed.Claims.(jwtGo.MapClaims)
+	now := h.Clock.Now()
+	claims["exp"] = now.Add(-time.Hour).Unix()
+	claims["iat"] = now.Add(-2 * time.Hour).Unix()
+	claims["nbf"] = now.Add(-2 * time.Hour).Unix()
+
+	token := jwtGo.NewWithClaims(jwtGo.SigningMethodHS256, claims)
+	token.Header = parsed.Header
+	signed, err := token.SignedString(h.key)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	return signed
+}
@@ -0,0 +1,80 @@
+package jwttest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+var modes = map[string]jwt.Options{
+	"cookies":              {},
+	"bearer tokens":        {BearerTokens: true},
+	"authorization header": {AuthorizationHeader: true},
+}
+
+var bob = jwt.ClaimsType{StandardClaims: jwtGo.StandardClaims{Subject: "bob"}}
+
+// subject writes the subject of the request's claims
+func subject(h *jwttest.Harness) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := h.Auth.GrabTokenClaims(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write([]byte(claims.StandardClaims.Subject))
+	})
+}
+
+func TestHarness(t *testing.T) {
+	for name, options := range modes {
+		t.Run(name, func(t *testing.T) {
+			h := jwttest.New(t, options)
+			tokens := h.Issue(bob)
+
+			w := h.Serve(subject(h), h.NewRequest("GET", "/", tokens))
+			if w.Code != 200 || w.Body.String() != "bob" {
+				t.Errorf("code = %d, body = %q", w.Code, w.Body)
+			}
+
+			// an expired auth token is refreshed
+			w = h.Serve(subject(h), h.NewRequest("GET", "/", h.ExpireAuthToken(tokens)))
+			if w.Code != 200 {
+				t.Fatalf("expired auth token code = %d", w.Code)
+			}
+			h.AssertTokensSet(w)
+			h.AssertExpiryHeaders(w)
+
+			for name, tokens := range map[string]jwttest.Tokens{
+				"expired refresh token": h.ExpireRefreshToken(h.ExpireAuthToken(tokens)),
+				"tampered auth token":   h.Tamper(tokens),
+			} {
+				if w := h.Serve(subject(h), h.NewRequest("GET", "/", tokens)); w.Code != 401 {
+					t.Errorf("%s code = %d, want 401", name, w.Code)
+				}
+			}
+
+			h.Revoke(tokens)
+			if w := h.Serve(subject(h), h.NewRequest("GET", "/", h.ExpireAuthToken(tokens))); w.Code != 401 {
+				t.Errorf("revoked refresh token code = %d, want 401", w.Code)
+			}
+		})
+	}
+}
+
+func TestHarnessLogout(t *testing.T) {
+	for name, options := range modes {
+		t.Run(name, func(t *testing.T) {
+			h := jwttest.New(t, options)
+			tokens := h.Issue(bob)
+
+			logout := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h.Auth.NullifyTokens(&w, r)
+			})
+			h.AssertTokensCleared(h.Serve(logout, h.NewRequest("POST", "/logout", tokens)))
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultKeyWatchInterval = 30 * time.Second
+
+// KeyWatchOptions configures WatchKeyFiles
+type KeyWatchOptions struct {
+	// Interval is how often the key files are checked for changes (30 seconds by default)
+	Interval time.Duration
+
+	// GracePeriod is how long tokens signed with the previous key stay valid after a reload.
+	// It defaults to RefreshTokenValidTime, so that no one is logged out by a reload.
+	GracePeriod time.Duration
+
+	// OnReload is called with the new kid after the keys have been reloaded
+	OnReload func(kid string)
+
+	// OnError is called when the key files can't be read or parsed. The current keys stay in
+	// use, and the files are tried again on the next check.
+	OnError func(err error)
+}
+
+// KeyWatcher reloads an Auth's key files when they change (see WatchKeyFiles)
+type KeyWatcher struct {
+	auth    *Auth
+	options KeyWatchOptions
+
+	mu       sync.Mutex
+	checksum [sha256.Size]byte
+	// retiring holds the kids of previous keys, and when their grace period ends
+	retiring map[string]time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// WatchKeyFiles polls PrivateKeyLocation and PublicKeyLocation, and reloads the keys when either
+// file changes, e.g. when a secrets agent rotates them. The new key becomes the active key, under
+// a thumbprint kid, and the previous key can still verify tokens for the grace period.
+// Only RSA, ECDSA and Ed25519 keys that were loaded from files can be watched.
+// Call Close to stop watching.
+func (a *Auth) WatchKeyFiles(options KeyWatchOptions) (*KeyWatcher, error) {
+	o := a.options
+	if strings.HasPrefix(o.SigningMethodString, "HS") || o.JWKSURL != "" {
+		return nil, errors.New("Only RSA, ECDSA and Ed25519 key files can be watched")
+	}
+	if o.SigningKey != nil || len(o.PrivateKeyBytes) > 0 || o.VerificationKey != nil || len(o.PublicKeyBytes) > 0 {
+		return nil, errors.New("Keys that weren't loaded from files can't be watched")
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = defaultKeyWatchInterval
+	}
+	if options.GracePeriod <= 0 {
+		options.GracePeriod = o.RefreshTokenValidTime
+	}
+
+	w := &KeyWatcher{
+		auth:     a,
+		options:  options,
+		retiring: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+	}
+
+	// the keys that New loaded are the starting point
+	checksum, err := w.readChecksum()
+	if err != nil {
+		return nil, err
+	}
+	w.checksum = checksum
+
+	go w.watch()
+
+	return w, nil
+}
+
+// Reload checks the key files now, rather than waiting for the next interval. Previous keys whose
+// grace period has ended are retired first.
+func (w *KeyWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.retireKeys()
+
+	checksum, err := w.readChecksum()
+	if err != nil {
+		return err
+	}
+	if checksum == w.checksum {
+		return nil
+	}
+
+	o := w.auth.options
+	signKey, verifyKey, err := loadKeys(o)
+	if err != nil {
+		return err
+	}
+	if err := checkKeyTypes(o.SigningMethodString, signKey, verifyKey); err != nil {
+		return err
+	}
+
+	kid, err := keyThumbprint(verifyKey)
+	if err != nil {
+		return err
+	}
+
+	// the files may be caught halfway through being rotated
+	if signKey != nil {
+		signerKid, err := keyThumbprint(signerPublicKey(signKey))
+		if err != nil {
+			return err
+		}
+		if signerKid != kid {
+			return errors.New("The private and public key files don't match")
+		}
+	}
+
+	w.checksum = checksum
+
+	previousKid, _ := w.auth.keys.signingKey()
+	if kid == previousKid {
+		return nil
+	}
+
+	w.auth.keys.setSigningKey(kid, signKey, verifyKey)
+	w.auth.myLog("Keys have been reloaded")
+
+	// the new key may be a previous one, made active again
+	delete(w.retiring, kid)
+	if previousKid != "" {
+		w.retiring[previousKid] = w.auth.now().Add(w.options.GracePeriod)
+	}
+
+	if w.options.OnReload != nil {
+		w.options.OnReload(kid)
+	}
+	return nil
+}
+
+// Close stops watching the key files. Previous keys that are still in their grace period are no
+// longer retired, so retire them with RetireKey once it ends.
+func (w *KeyWatcher) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *KeyWatcher) watch() {
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Reload(); err != nil {
+				w.auth.myLog("Error reloading keys: " + err.Error())
+				if w.options.OnError != nil {
+					w.options.OnError(err)
+				}
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// retireKeys retires the previous keys whose grace period has ended by the Options.Clock. It runs on
+// every check, so keys are retired within an Interval of their grace period ending.
+func (w *KeyWatcher) retireKeys() {
+	now := w.auth.now()
+	for kid, retireAt := range w.retiring {
+		if now.Before(retireAt) {
+			continue
+		}
+
+		delete(w.retiring, kid)
+		if err := w.auth.keys.retire(kid); err != nil {
+			w.auth.myLog("Error retiring key " + kid + ": " + err.Error())
+			continue
+		}
+		w.auth.myLog("Retired key " + kid)
+	}
+}
+
+// readChecksum hashes the contents of the key files. The contents are compared, rather than the
+// modification times, because secrets are often swapped in by replacing a symlink.
+func (w *KeyWatcher) readChecksum() ([sha256.Size]byte, error) {
+	var contents bytes.Buffer
+	for _, location := range []string{w.auth.options.PrivateKeyLocation, w.auth.options.PublicKeyLocation} {
+		if location == "" {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		contents.Write(data)
+		contents.WriteByte(0)
+	}
+
+	return sha256.Sum256(contents.Bytes()), nil
+}
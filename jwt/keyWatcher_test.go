@@ -0,0 +1,193 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// writeKeyFiles writes a new P-256 key pair to the PEM files
+func writeKeyFiles(t *testing.T, privateKeyLocation string, publicKeyLocation string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(privateKeyLocation, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(publicKeyLocation, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// issuedRequest issues tokens for bob, and returns a request that carries the auth token in the
+// Authorization header, but not the refresh token, so that the auth token must verify by itself
+func issuedRequest(t *testing.T, auth *jwt.Auth) *http.Request {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := auth.IssueNewTokens(w, bob); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+w.Header().Get("Auth-Token"))
+	r.Header.Set("X-CSRF-Token", w.Header().Get("X-CSRF-Token"))
+	return r
+}
+
+// verifies is true if the middleware lets the request through
+func verifies(auth *jwt.Auth, r *http.Request) bool {
+	w := httptest.NewRecorder()
+	auth.Handler(okHandler).ServeHTTP(w, r)
+	return w.Code == 200
+}
+
+func TestWatchKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	privateKeyLocation, publicKeyLocation := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub")
+	writeKeyFiles(t, privateKeyLocation, publicKeyLocation)
+
+	clock := jwttest.NewFakeClock(time.Now())
+	var auth jwt.Auth
+	err := jwt.New(&auth, jwt.Options{
+		SigningMethodString: "ES256",
+		PrivateKeyLocation:  privateKeyLocation,
+		PublicKeyLocation:   publicKeyLocation,
+		AuthorizationHeader: true,
+		IsDevEnv:            true,
+		Clock:               clock,
+		// the tokens mustn't expire before the grace period ends
+		AuthTokenValidTime:    24 * time.Hour,
+		RefreshTokenValidTime: 48 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reloaded []string
+	watcher, err := auth.WatchKeyFiles(jwt.KeyWatchOptions{
+		Interval:    time.Hour,
+		GracePeriod: time.Hour,
+		OnReload: func(kid string) {
+			reloaded = append(reloaded, kid)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	oldKid := auth.KeyIds()[0]
+	old := issuedRequest(t, &auth)
+	if err := watcher.Reload(); err != nil || len(reloaded) != 0 {
+		t.Fatalf("unchanged files: err = %v, reloaded = %v", err, reloaded)
+	}
+
+	writeKeyFiles(t, privateKeyLocation, publicKeyLocation)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded) != 1 || reloaded[0] == oldKid {
+		t.Fatalf("reloaded = %v", reloaded)
+	}
+	newKid := reloaded[0]
+	current := issuedRequest(t, &auth)
+
+	// during the grace period, both keys verify
+	kids := auth.KeyIds()
+	sort.Strings(kids)
+	want := []string{oldKid, newKid}
+	sort.Strings(want)
+	if strings.Join(kids, ",") != strings.Join(want, ",") {
+		t.Errorf("kids = %v, want %v", kids, want)
+	}
+	if !verifies(&auth, old) || !verifies(&auth, current) {
+		t.Error("a token doesn't verify during the grace period")
+	}
+
+	// afterwards, only the new key does
+	clock.Advance(time.Hour)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kids := auth.KeyIds(); len(kids) != 1 || kids[0] != newKid {
+		t.Errorf("kids = %v, want [%s]", kids, newKid)
+	}
+	if verifies(&auth, old) {
+		t.Error("the old key's token verifies after the grace period")
+	}
+	if !verifies(&auth, current) {
+		t.Error("the new key's token doesn't verify")
+	}
+}
+
+func TestWatchKeyFilesKeepsKeysOnError(t *testing.T) {
+	dir := t.TempDir()
+	privateKeyLocation, publicKeyLocation := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub")
+	writeKeyFiles(t, privateKeyLocation, publicKeyLocation)
+
+	var auth jwt.Auth
+	err := jwt.New(&auth, jwt.Options{
+		SigningMethodString: "ES256",
+		PrivateKeyLocation:  privateKeyLocation,
+		PublicKeyLocation:   publicKeyLocation,
+		AuthorizationHeader: true,
+		IsDevEnv:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := auth.WatchKeyFiles(jwt.KeyWatchOptions{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	kids := auth.KeyIds()
+
+	// a bad key file, and a private key that doesn't match the public key, as if caught halfway
+	// through a rotation
+	other := filepath.Join(dir, "other.pub")
+	for name, write := range map[string]func(){
+		"bad private key": func() {
+			ioutil.WriteFile(privateKeyLocation, []byte("not a key"), 0600)
+		},
+		"mismatched keys": func() {
+			writeKeyFiles(t, privateKeyLocation, other)
+		},
+	} {
+		write()
+		if err := watcher.Reload(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		if got := auth.KeyIds(); len(got) != 1 || got[0] != kids[0] {
+			t.Errorf("%s: kids = %v, want %v", name, got, kids)
+		}
+		if !verifies(&auth, issuedRequest(t, &auth)) {
+			t.Errorf("%s: tokens issued with the current key don't verify", name)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"sync"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// keyring holds one active signing key and any number of verification keys, indexed by kid
+type keyring struct {
+	mu sync.RWMutex
+
+	// the kid of the active key. Tokens without a kid header are verified with this key.
+	activeKeyId string
+	signKey     interface{}
+	verifyKeys  map[string]interface{}
+}
+
+func newKeyring(kid string, signKey interface{}, verifyKey interface{}) *keyring {
+	return &keyring{
+		activeKeyId: kid,
+		signKey:     signKey,
+		verifyKeys:  map[string]interface{}{kid: verifyKey},
+	}
+}
+
+func (k *keyring) signingKey() (kid string, signKey interface{}) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeKeyId, k.signKey
+}
+
+func (k *keyring) verifyKey(kid string) (interface{}, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if kid == "" {
+		kid = k.activeKeyId
+	}
+	verifyKey, ok := k.verifyKeys[kid]
+	return verifyKey, ok
+}
+
+func (k *keyring) addVerifyKey(kid string, verifyKey interface{}) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.verifyKeys[kid] = verifyKey
+}
+
+// replaceVerifyKeys swaps in a new set of verification keys. If there is only one key,
+// tokens without a kid are verified with it.
+func (k *keyring) replaceVerifyKeys(verifyKeys map[string]interface{}) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.verifyKeys = verifyKeys
+	k.activeKeyId = ""
+	if len(verifyKeys) == 1 {
+		for kid := range verifyKeys {
+			k.activeKeyId = kid
+		}
+	}
+}
+
+func (k *keyring) setSigningKey(kid string, signKey interface{}, verifyKey interface{}) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.activeKeyId = kid
+	k.signKey = signKey
+	k.verifyKeys[kid] = verifyKey
+}
+
+func (k *keyring) retire(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if kid == k.activeKeyId {
+		return errors.New("The active key can't be retired")
+	}
+	if _, ok := k.verifyKeys[kid]; !ok {
+		return ErrUnknownKeyId
+	}
+
+	delete(k.verifyKeys, kid)
+	return nil
+}
+
+func (k *keyring) keyIds() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	kids := make([]string, 0, len(k.verifyKeys))
+	for kid := range k.verifyKeys {
+		kids = append(kids, kid)
+	}
+	return kids
+}
+
+// AddVerificationKey adds a key that tokens stamped with the given kid will be verified with.
+// Use this to accept tokens signed by a key that another server is rotating in.
+func (a *Auth) AddVerificationKey(kid string, verifyKey interface{}) error {
+	if kid == "" {
+		return errors.New("A kid is required")
+	}
+	if err := checkKeyTypes(a.options.SigningMethodString, nil, verifyKey); err != nil {
+		return err
+	}
+
+	a.keys.addVerifyKey(kid, verifyKey)
+	return nil
+}
+
+// SetSigningKey makes the given key pair the active key. New tokens are signed with the
+// sign key and stamped with the kid. Tokens signed by the previous key remain valid until
+// its kid is retired.
+func (a *Auth) SetSigningKey(kid string, signKey interface{}, verifyKey interface{}) error {
+	if a.options.VerifyOnlyServer {
+		return ErrVerifyOnly
+	}
+	if kid == "" {
+		return errors.New("A kid is required")
+	}
+	if err := checkKeyTypes(a.options.SigningMethodString, signKey, verifyKey); err != nil {
+		return err
+	}
+
+	a.keys.setSigningKey(kid, signKey, verifyKey)
+	return nil
+}
+
+// RetireKey removes a verification key. Tokens stamped with its kid will no longer be valid.
+func (a *Auth) RetireKey(kid string) error {
+	return a.keys.retire(kid)
+}
+
+// KeyIds returns the kids of all of the verification keys
+func (a *Auth) KeyIds() []string {
+	return a.keys.keyIds()
+}
+
+// signToken stamps the token with the active key's kid and signs it
+func (a *Auth) signToken(token *jwtGo.Token) (string, error) {
+	kid, signKey := a.keys.signingKey()
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	// keys that jwt-go doesn't know, e.g. in an HSM, sign through the crypto.Signer interface.
+	// So does PSS, because jwt-go uses the longest salt it can, and RFC 7518 wants the salt to be
+	// as long as the hash.
+	if signer, ok := signKey.(crypto.Signer); ok {
+		if _, isPSS := token.Method.(*jwtGo.SigningMethodRSAPSS); isPSS || !isNativeKey(signKey) {
+			return signWithSigner(token, signer)
+		}
+	}
+
+	return token.SignedString(signKey)
+}
+
+// checkKeyTypes makes sure the keys can be used with the signing method.
+// A nil key is not checked.
+func checkKeyTypes(signingMethodString string, signKey interface{}, verifyKey interface{}) error {
+	var signOk, verifyOk bool
+	switch signingMethodString {
+	case "HS256", "HS384", "HS512":
+		_, signOk = signKey.([]byte)
+		_, verifyOk = verifyKey.([]byte)
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		_, signOk = signerPublicKey(signKey).(*rsa.PublicKey)
+		_, verifyOk = verifyKey.(*rsa.PublicKey)
+	case "ES256", "ES384", "ES512":
+		_, signOk = signerPublicKey(signKey).(*ecdsa.PublicKey)
+		_, verifyOk = verifyKey.(*ecdsa.PublicKey)
+	case "EdDSA":
+		_, signOk = signerPublicKey(signKey).(ed25519.PublicKey)
+		_, verifyOk = verifyKey.(ed25519.PublicKey)
+	default:
+		return errors.New("Signing method string not recognized!")
+	}
+
+	if (signKey != nil && !signOk) || (verifyKey != nil && !verifyOk) {
+		return errors.New("Key type doesn't match the signing method")
+	}
+	return nil
+}
+
+// keyThumbprint derives a kid from a public key: the base64url encoded sha256 of its DER encoding
+func keyThumbprint(verifyKey interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(verifyKey)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
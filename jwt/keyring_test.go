@@ -0,0 +1,109 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+)
+
+func generateECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func sortedKeyIds(auth *jwt.Auth) string {
+	kids := auth.KeyIds()
+	sort.Strings(kids)
+	return strings.Join(kids, ",")
+}
+
+func TestKeyRotation(t *testing.T) {
+	one, two := generateECKey(t), generateECKey(t)
+	auth := newSigningAuth(t, "ES256", one)
+	first := issuedRequest(t, auth)
+
+	// another server signs with the key that's being rotated in
+	var other jwt.Auth
+	if err := jwt.New(&other, jwt.Options{SigningMethodString: "ES256", SigningKey: two, KeyId: "two", AuthorizationHeader: true, IsDevEnv: true}); err != nil {
+		t.Fatal(err)
+	}
+	if verifies(auth, issuedRequest(t, &other)) {
+		t.Fatal("a token with an unknown kid was accepted")
+	}
+	if err := auth.AddVerificationKey("two", &two.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if !verifies(auth, issuedRequest(t, &other)) {
+		t.Error("a token signed with the added key doesn't verify")
+	}
+	if sortedKeyIds(auth) != "key,two" {
+		t.Errorf("kids = %s", sortedKeyIds(auth))
+	}
+
+	// once it's the signing key, tokens signed by either key verify
+	if err := auth.SetSigningKey("two", two, &two.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	second := issuedRequest(t, auth)
+	if !verifies(auth, first) || !verifies(auth, second) {
+		t.Error("a token doesn't verify after the rotation")
+	}
+
+	if err := auth.RetireKey("two"); err == nil {
+		t.Error("the active key was retired")
+	}
+	if err := auth.RetireKey("unknown"); err != jwt.ErrUnknownKeyId {
+		t.Errorf("retiring an unknown kid = %v, want ErrUnknownKeyId", err)
+	}
+	if err := auth.RetireKey("key"); err != nil {
+		t.Fatal(err)
+	}
+	if verifies(auth, first) {
+		t.Error("a token signed with the retired key verifies")
+	}
+	if !verifies(auth, second) {
+		t.Error("a token signed with the active key doesn't verify")
+	}
+	if sortedKeyIds(auth) != "two" {
+		t.Errorf("kids = %s", sortedKeyIds(auth))
+	}
+}
+
+func TestUnknownKeyId(t *testing.T) {
+	auth := newSigningAuth(t, "ES256", generateECKey(t))
+	var unauthorizedErr error
+	auth.SetUnauthorizedHandlerWithError(func(w http.ResponseWriter, r *http.Request, err error) {
+		unauthorizedErr = err
+		http.Error(w, "Unauthorized", 401)
+	})
+
+	var other jwt.Auth
+	if err := jwt.New(&other, jwt.Options{SigningMethodString: "ES256", SigningKey: generateECKey(t), KeyId: "other", AuthorizationHeader: true, IsDevEnv: true}); err != nil {
+		t.Fatal(err)
+	}
+	if verifies(auth, issuedRequest(t, &other)) || unauthorizedErr != jwt.ErrUnknownKeyId {
+		t.Errorf("unauthorized error = %v, want ErrUnknownKeyId", unauthorizedErr)
+	}
+}
+
+func TestAddVerificationKeyChecksTheKey(t *testing.T) {
+	auth := newSigningAuth(t, "ES256", generateECKey(t))
+
+	if err := auth.AddVerificationKey("", &generateECKey(t).PublicKey); err == nil {
+		t.Error("a key without a kid was added")
+	}
+	if err := auth.AddVerificationKey("hmac", []byte("secret")); err == nil {
+		t.Error("an HMAC key was added to an ES256 server")
+	}
+}
@@ -0,0 +1,222 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// loadKeys returns the sign and verify keys for an RSA, ECDSA or EdDSA signing method. Each key comes from
+// the first option that is set: SigningKey, PrivateKeyBytes or PrivateKeyLocation for the sign key,
+// and VerificationKey, PublicKeyBytes, PublicKeyLocation or the sign key's public key for the verify key.
+func loadKeys(o Options) (signKey interface{}, verifyKey interface{}, err error) {
+	if !o.VerifyOnlyServer {
+		switch {
+		case o.SigningKey != nil:
+			signKey = o.SigningKey
+		case len(o.PrivateKeyBytes) > 0:
+			signKey, err = parsePrivateKey(o.PrivateKeyBytes)
+		case o.PrivateKeyLocation != "":
+			var signBytes []byte
+			signBytes, err = ioutil.ReadFile(o.PrivateKeyLocation)
+			if err == nil {
+				signKey, err = parsePrivateKey(signBytes)
+			}
+		default:
+			err = errors.New("A private key or signer is required!")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case o.VerificationKey != nil:
+		verifyKey = o.VerificationKey
+	case len(o.PublicKeyBytes) > 0:
+		verifyKey, err = parsePublicKey(o.PublicKeyBytes)
+	case o.PublicKeyLocation != "":
+		var verifyBytes []byte
+		verifyBytes, err = ioutil.ReadFile(o.PublicKeyLocation)
+		if err == nil {
+			verifyKey, err = parsePublicKey(verifyBytes)
+		}
+	case signKey != nil:
+		// the public key can be derived from the private key
+		verifyKey = signerPublicKey(signKey)
+	default:
+		err = errors.New("A public key is required!")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return signKey, verifyKey, nil
+}
+
+// parsePrivateKey parses an RSA, ECDSA or Ed25519 private key that is PEM or DER encoded
+// (PKCS #1, SEC 1 or PKCS #8). Either may also be base64 encoded, as is common in environment variables.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	der, err := keyDER(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("Private key is not a valid RSA, ECDSA or Ed25519 key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Private key is not a valid RSA, ECDSA or Ed25519 key")
+	}
+	return signer, nil
+}
+
+// parsePublicKey parses a public key or certificate that is PEM or DER encoded (PKIX, PKCS #1 or
+// X.509). Either may also be base64 encoded.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	der, err := keyDER(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key, nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.New("Public key is not a valid public key or certificate")
+	}
+	return cert.PublicKey, nil
+}
+
+// keyDER returns the DER bytes of a PEM, DER or base64 encoded key
+func keyDER(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if block, _ := pem.Decode(data); block != nil {
+		return block.Bytes, nil
+	}
+
+	// DER is an ASN.1 sequence
+	if len(data) > 0 && data[0] == 0x30 {
+		return data, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, errors.New("Key is not PEM, DER or base64 encoded")
+	}
+	if block, _ := pem.Decode(decoded); block != nil {
+		return block.Bytes, nil
+	}
+	return decoded, nil
+}
+
+// signWithSigner signs the token with a crypto.Signer, e.g. a key in an HSM, that jwt-go can't use
+// directly. Only the digest is passed to the signer, never the key material.
+func signWithSigner(token *jwtGo.Token, signer crypto.Signer) (string, error) {
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	var hash crypto.Hash
+	var opts crypto.SignerOpts
+	var ecdsaKeySize int
+	switch method := token.Method.(type) {
+	case *SigningMethodEd25519:
+		// Ed25519 signs the message itself, which the signing method already does with a signer
+		return token.SignedString(signer)
+	case *jwtGo.SigningMethodRSAPSS:
+		hash = method.Hash
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case *jwtGo.SigningMethodRSA:
+		hash = method.Hash
+		opts = hash
+	case *jwtGo.SigningMethodECDSA:
+		hash = method.Hash
+		opts = hash
+		ecdsaKeySize = method.KeySize
+	default:
+		return "", ErrInvalidSigningMethod
+	}
+
+	if !hash.Available() {
+		return "", jwtGo.ErrHashUnavailable
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signingString))
+
+	sig, err := signer.Sign(rand.Reader, hasher.Sum(nil), opts)
+	if err != nil {
+		return "", err
+	}
+
+	// crypto.Signer returns ASN.1 encoded ECDSA signatures, but jws wants r || s
+	if ecdsaKeySize > 0 {
+		sig, err = ecdsaRawSignature(sig, ecdsaKeySize)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return signingString + "." + jwtGo.EncodeSegment(sig), nil
+}
+
+func ecdsaRawSignature(der []byte, keySize int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 2*keySize)
+	rBytes, sBytes := sig.R.Bytes(), sig.S.Bytes()
+	if len(rBytes) > keySize || len(sBytes) > keySize {
+		return nil, errors.New("ECDSA signature doesn't match the signing method's key size")
+	}
+	copy(raw[keySize-len(rBytes):keySize], rBytes)
+	copy(raw[2*keySize-len(sBytes):], sBytes)
+	return raw, nil
+}
+
+// signerPublicKey returns the public key of a signer, or nil.
+// *rsa.PrivateKey and *ecdsa.PrivateKey are signers too.
+func signerPublicKey(signKey interface{}) crypto.PublicKey {
+	if signer, ok := signKey.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+// isNativeKey is true if jwt-go (or SigningMethodEd25519) can sign with the key itself
+func isNativeKey(signKey interface{}) bool {
+	switch signKey.(type) {
+	case []byte, *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return true
+	}
+	return false
+}
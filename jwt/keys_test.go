@@ -0,0 +1,200 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+func pemBlock(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func base64Bytes(b []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(b))
+}
+
+func mustDER(t *testing.T) func(der []byte, err error) []byte {
+	return func(der []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+}
+
+// selfSignedCertificate returns a DER encoded certificate for the key
+func selfSignedCertificate(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "jwt-auth"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestKeyFormats(t *testing.T) {
+	der := mustDER(t)
+	rsaKey, ecKey, edKey := generateKeys(t)
+	pkcs8 := func(key interface{}) []byte { return der(x509.MarshalPKCS8PrivateKey(key)) }
+	pkix := func(key interface{}) []byte { return der(x509.MarshalPKIXPublicKey(key)) }
+
+	dir := t.TempDir()
+	privateKeyLocation, publicKeyLocation := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub")
+	if err := ioutil.WriteFile(privateKeyLocation, pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(publicKeyLocation, pemBlock("PUBLIC KEY", pkix(&rsaKey.PublicKey)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, o := range map[string]jwt.Options{
+		"PKCS #1 PEM": {
+			SigningMethodString: "RS256",
+			PrivateKeyBytes:     pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			PublicKeyBytes:      pemBlock("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+		},
+		"PKCS #8 DER": {
+			SigningMethodString: "PS256",
+			PrivateKeyBytes:     pkcs8(rsaKey),
+			PublicKeyBytes:      pkix(&rsaKey.PublicKey),
+		},
+		"files": {
+			SigningMethodString: "RS256",
+			PrivateKeyLocation:  privateKeyLocation,
+			PublicKeyLocation:   publicKeyLocation,
+		},
+		"SEC 1 PEM and a certificate": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     pemBlock("EC PRIVATE KEY", der(x509.MarshalECPrivateKey(ecKey))),
+			PublicKeyBytes:      pemBlock("CERTIFICATE", selfSignedCertificate(t, ecKey)),
+		},
+		"base64 PEM": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     base64Bytes(pemBlock("PRIVATE KEY", pkcs8(ecKey))),
+			PublicKeyBytes:      base64Bytes(pemBlock("PUBLIC KEY", pkix(&ecKey.PublicKey))),
+		},
+		"base64 DER": {
+			SigningMethodString: "EdDSA",
+			PrivateKeyBytes:     base64Bytes(pkcs8(edKey)),
+			PublicKeyBytes:      base64Bytes(pkix(edKey.Public())),
+		},
+		"derived public key": {
+			SigningMethodString: "EdDSA",
+			PrivateKeyBytes:     pemBlock("PRIVATE KEY", pkcs8(edKey)),
+		},
+		"crypto.Signer": {
+			SigningMethodString: "ES384",
+			SigningKey:          opaqueSigner{ecKey},
+			VerificationKey:     &ecKey.PublicKey,
+		},
+	} {
+		o.AuthorizationHeader = true
+		o.IsDevEnv = true
+
+		var auth jwt.Auth
+		if err := jwt.New(&auth, o); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !verifies(&auth, issuedRequest(t, &auth)) {
+			t.Errorf("%s: the token doesn't verify", name)
+		}
+	}
+}
+
+func TestBadKeys(t *testing.T) {
+	der := mustDER(t)
+	rsaKey, ecKey, _ := generateKeys(t)
+	otherKey := generateECKey(t)
+	ecPEM := pemBlock("EC PRIVATE KEY", der(x509.MarshalECPrivateKey(ecKey)))
+
+	for name, o := range map[string]jwt.Options{
+		"not a key": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     []byte("not a key"),
+		},
+		"truncated": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     ecPEM[:len(ecPEM)/2],
+		},
+		"not base64 DER of a key": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     base64Bytes([]byte("not a key")),
+		},
+		"the wrong type of key": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+		},
+		"the wrong type of public key": {
+			SigningMethodString: "ES384",
+			PrivateKeyBytes:     ecPEM,
+			PublicKeyBytes:      pemBlock("PUBLIC KEY", der(x509.MarshalPKIXPublicKey(&rsaKey.PublicKey))),
+		},
+		"a missing file": {
+			SigningMethodString: "ES384",
+			PrivateKeyLocation:  filepath.Join(t.TempDir(), "missing.pem"),
+		},
+		"no key": {
+			SigningMethodString: "ES256",
+		},
+		"a signer of the wrong type": {
+			SigningMethodString: "ES256",
+			SigningKey:          opaqueSigner{rsaKey},
+			VerificationKey:     &otherKey.PublicKey,
+		},
+	} {
+		var auth jwt.Auth
+		if err := jwt.New(&auth, o); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestECDSASignerSignatures(t *testing.T) {
+	// P-521 coordinates are 66 bytes, but half of them have a leading zero byte, which must be
+	// kept in the r || s signature
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := newSigningAuth(t, "ES512", opaqueSigner{key})
+
+	for i := 0; i < 32; i++ {
+		r := issuedRequest(t, auth)
+		authToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		sig, err := jwtGo.DecodeSegment(authToken[strings.LastIndex(authToken, ".")+1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sig) != 2*66 {
+			t.Fatalf("signature is %d bytes, want %d", len(sig), 2*66)
+		}
+		if _, err := jwtGo.Parse(authToken, func(*jwtGo.Token) (interface{}, error) { return &key.PublicKey, nil }); err != nil {
+			t.Fatalf("jwt-go can't verify the signature: %v", err)
+		}
+	}
+}
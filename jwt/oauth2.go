@@ -0,0 +1,361 @@
+package jwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/adam-hanna/randomstrings"
+)
+
+// OAuth2 grant types (https://tools.ietf.org/html/rfc6749)
+const (
+	GrantTypePassword          = "password"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeAuthorizationCode = "authorization_code"
+)
+
+// error codes for token endpoint responses
+// https://tools.ietf.org/html/rfc6749#section-5.2
+const (
+	oauth2ErrorInvalidRequest       = "invalid_request"
+	oauth2ErrorInvalidClient        = "invalid_client"
+	oauth2ErrorInvalidGrant         = "invalid_grant"
+	oauth2ErrorUnauthorizedClient   = "unauthorized_client"
+	oauth2ErrorUnsupportedGrantType = "unsupported_grant_type"
+	oauth2ErrorInvalidScope         = "invalid_scope"
+)
+
+// OAuth2Authenticator checks the credentials that are presented to the TokenEndpoint
+// (see SetOAuth2Authenticator). Return ErrInvalidClient, ErrInvalidGrant or ErrUnauthorizedClient
+// to reject a request; any other error is an internal server error.
+type OAuth2Authenticator interface {
+	// AuthenticateClient checks the client's id and secret, which are taken from http basic auth
+	// or from the client_id and client_secret form values. The secret is empty for public clients,
+	// e.g. mobile apps.
+	AuthenticateClient(r *http.Request, clientId string, clientSecret string) error
+
+	// AuthenticateUser checks a resource owner's username and password for the password grant,
+	// and returns the claims to issue the tokens with. The requested scope is r.FormValue("scope").
+	// Nil claims are an invalid grant.
+	AuthenticateUser(r *http.Request, clientId string, username string, password string) (Claims, error)
+
+	// ClientClaims returns the claims to issue an auth token to the client itself with, for the
+	// client_credentials grant. The requested scope is r.FormValue("scope").
+	ClientClaims(r *http.Request, clientId string) (Claims, error)
+}
+
+// SetOAuth2Authenticator registers the authenticator that the TokenEndpoint checks credentials with
+func (a *Auth) SetOAuth2Authenticator(authenticator OAuth2Authenticator) {
+	a.oauth2Authenticator = authenticator
+}
+
+// tokenResponse is a successful token endpoint response
+// https://tools.ietf.org/html/rfc6749#section-5.1
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+}
+
+type oauth2ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// TokenEndpoint returns an OAuth2 (RFC 6749) token endpoint that supports the password,
+// refresh_token, client_credentials and authorization_code (see AuthorizeHandler) grants. Credentials are checked by the OAuth2Authenticator
+// (see SetOAuth2Authenticator), and tokens are issued like IssueNewTokens would, bound to the client.
+// Clients send the access token in an "Authorization: Bearer" header, so use it with the
+// AuthorizationHeader option. The refresh token is rotated if RotateRefreshTokens is set.
+func (a *Auth) TokenEndpoint() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		if a.options.VerifyOnlyServer {
+			a.myLog("Server is not authorized to issue new tokens")
+			a.errorHandler(w, r, ErrVerifyOnly)
+			return
+		}
+		clientId, usedBasicAuth, ok := a.authenticateOAuth2Client(w, r)
+		if !ok {
+			return
+		}
+
+		var response tokenResponse
+		var err error
+		switch grantType := r.PostForm.Get("grant_type"); grantType {
+		case GrantTypePassword:
+			response, err = a.passwordGrant(r, clientId)
+		case GrantTypeRefreshToken:
+			response, err = a.refreshTokenGrant(r, clientId)
+		case GrantTypeClientCredentials:
+			response, err = a.clientCredentialsGrant(r, clientId)
+		case GrantTypeAuthorizationCode:
+			response, err = a.authorizationCodeGrant(r, clientId)
+		case "":
+			writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "No grant_type")
+			return
+		default:
+			writeOAuth2Error(w, 400, oauth2ErrorUnsupportedGrantType, "")
+			return
+		}
+		if err != nil {
+			a.oauth2Failure(w, r, err, usedBasicAuth)
+			return
+		}
+
+		a.myLog("Successfully issued OAuth2 tokens")
+		writeOAuth2JSON(w, 200, response)
+	})
+}
+
+func (a *Auth) passwordGrant(r *http.Request, clientId string) (tokenResponse, error) {
+	username := r.PostForm.Get("username")
+	password := r.PostForm.Get("password")
+	if username == "" || password == "" {
+		return tokenResponse{}, oauth2Error{oauth2ErrorInvalidRequest, "No username or password"}
+	}
+
+	claims, err := a.oauth2Authenticator.AuthenticateUser(r, clientId, username, password)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if claims == nil {
+		a.myLog("Authenticator returned no claims for the user!")
+		return tokenResponse{}, ErrInvalidGrant
+	}
+	claims.Base().ClientId = clientId
+
+	authTokenString, refreshTokenString, _, claims, err := a.newTokens(r, claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return a.newUserTokenResponse(r, authTokenString, refreshTokenString, claims, "")
+}
+
+func (a *Auth) refreshTokenGrant(r *http.Request, clientId string) (tokenResponse, error) {
+	refreshTokenString := r.PostForm.Get("refresh_token")
+	if refreshTokenString == "" {
+		return tokenResponse{}, oauth2Error{oauth2ErrorInvalidRequest, "No refresh_token"}
+	}
+	requestedScope := r.PostForm.Get("scope")
+
+	// refreshTokens only accepts tokens that are typed as refresh tokens, so an access token,
+	// which shares its id with its refresh token, is an invalid grant
+	authTokenString, refreshTokenString, csrfSecret, claims, err := a.refreshTokens(refreshTokenString, func(claims Claims) error {
+		// refresh tokens are bound to the client they were issued to
+		// https://tools.ietf.org/html/rfc6749#section-6
+		if claims.Base().ClientId != clientId {
+			a.myLog("Refresh token was issued to another client!")
+			return ErrInvalidGrant
+		}
+		// the scope can't be widened
+		for _, scope := range strings.Fields(requestedScope) {
+			if !claims.Base().HasScope(scope) {
+				return oauth2Error{oauth2ErrorInvalidScope, "The requested scope exceeds the scope originally granted"}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if err := a.recordSession(r, claims); err != nil {
+		return tokenResponse{}, err
+	}
+
+	// the access token is issued with the narrower scope, but the refresh token keeps the scope
+	// originally granted
+	// https://tools.ietf.org/html/rfc6749#section-6
+	if requestedScope != "" && requestedScope != claims.Base().Scope {
+		claims, err = a.copyClaims(claims)
+		if err != nil {
+			return tokenResponse{}, err
+		}
+		claims.Base().Scope = strings.Join(strings.Fields(requestedScope), " ")
+		authTokenString, err = a.createAuthTokenString(claims, csrfSecret)
+		if err != nil {
+			return tokenResponse{}, err
+		}
+	}
+
+	return a.newUserTokenResponse(r, authTokenString, refreshTokenString, claims, "")
+}
+
+// clientCredentialsGrant issues an auth token, but no refresh token, to the client itself.
+// https://tools.ietf.org/html/rfc6749#section-4.4.3
+func (a *Auth) clientCredentialsGrant(r *http.Request, clientId string) (tokenResponse, error) {
+	claims, err := a.oauth2Authenticator.ClientClaims(r, clientId)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	claims, err = a.copyClaims(claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	base := claims.Base()
+	base.ClientId = clientId
+	if base.Subject == "" {
+		base.Subject = clientId
+	}
+	if base.StandardClaims.Id == "" {
+		base.StandardClaims.Id, err = randomstrings.GenerateRandomString(32)
+		if err != nil {
+			return tokenResponse{}, err
+		}
+	}
+	a.stampClaims(base)
+
+	// the csrf secret is never given out, so these tokens can't be used with cookies
+	csrfSecret, err := randomstrings.GenerateRandomString(32)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	authTokenString, err := a.createAuthTokenString(claims, csrfSecret)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return a.newTokenResponse(authTokenString, "", claims), nil
+}
+
+func (a *Auth) newTokenResponse(authTokenString string, refreshTokenString string, claims Claims) tokenResponse {
+	return tokenResponse{
+		AccessToken:  authTokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(a.options.AuthTokenValidTime.Seconds()),
+		RefreshToken: refreshTokenString,
+		Scope:        claims.Base().Scope,
+	}
+}
+
+// newUserTokenResponse also issues an id token, if the "openid" scope was granted
+func (a *Auth) newUserTokenResponse(r *http.Request, authTokenString string, refreshTokenString string, claims Claims, nonce string) (tokenResponse, error) {
+	response := a.newTokenResponse(authTokenString, refreshTokenString, claims)
+	if !claims.Base().HasScope(ScopeOpenId) {
+		return response, nil
+	}
+
+	var err error
+	response.IdToken, err = a.createIdTokenString(r, claims, authTokenString, nonce)
+	return response, err
+}
+
+// authenticateOAuth2Client checks the credentials of the client that's calling an OAuth2 endpoint,
+// and writes the error response if they aren't valid
+func (a *Auth) authenticateOAuth2Client(w http.ResponseWriter, r *http.Request) (clientId string, usedBasicAuth bool, ok bool) {
+	if a.oauth2Authenticator == nil {
+		a.myLog(ErrNoOAuth2Authenticator)
+		a.errorHandler(w, r, ErrNoOAuth2Authenticator)
+		return "", false, false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "The request body can't be parsed")
+		return "", false, false
+	}
+
+	clientId, clientSecret, usedBasicAuth, ok := oauth2ClientCredentials(r)
+	if !ok {
+		writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "Client credentials were sent more than once")
+		return "", false, false
+	}
+	if clientId == "" {
+		writeOAuth2Error(w, 401, oauth2ErrorInvalidClient, "No client id")
+		return "", false, false
+	}
+	if err := a.oauth2Authenticator.AuthenticateClient(r, clientId, clientSecret); err != nil {
+		a.oauth2Failure(w, r, err, usedBasicAuth)
+		return "", false, false
+	}
+
+	return clientId, usedBasicAuth, true
+}
+
+// oauth2ClientCredentials returns the client's id and secret from http basic auth or the form.
+// ok is false if both were used.
+// https://tools.ietf.org/html/rfc6749#section-2.3.1
+func oauth2ClientCredentials(r *http.Request) (clientId string, clientSecret string, usedBasicAuth bool, ok bool) {
+	formClientId := r.PostForm.Get("client_id")
+	formClientSecret := r.PostForm.Get("client_secret")
+
+	basicClientId, basicClientSecret, usedBasicAuth := r.BasicAuth()
+	if !usedBasicAuth {
+		return formClientId, formClientSecret, false, true
+	}
+	if formClientSecret != "" || (formClientId != "" && formClientId != basicClientId) {
+		return "", "", true, false
+	}
+
+	// the id and secret are form encoded before they are put in the header
+	clientId, err := url.QueryUnescape(basicClientId)
+	if err != nil {
+		return "", "", true, false
+	}
+	clientSecret, err = url.QueryUnescape(basicClientSecret)
+	if err != nil {
+		return "", "", true, false
+	}
+	return clientId, clientSecret, true, true
+}
+
+// oauth2Error is a token endpoint error, with its code
+type oauth2Error struct {
+	code        string
+	description string
+}
+
+func (e oauth2Error) Error() string {
+	return e.description
+}
+
+// oauth2Failure writes the token endpoint error response for err
+func (a *Auth) oauth2Failure(w http.ResponseWriter, r *http.Request, err error, usedBasicAuth bool) {
+	a.myLog(err)
+
+	if e, ok := err.(oauth2Error); ok {
+		writeOAuth2Error(w, 400, e.code, e.description)
+		return
+	}
+
+	switch {
+	case err == ErrInvalidClient:
+		// https://tools.ietf.org/html/rfc6749#section-5.2
+		if usedBasicAuth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeOAuth2Error(w, 401, oauth2ErrorInvalidClient, err.Error())
+	case err == ErrUnauthorizedClient:
+		writeOAuth2Error(w, 400, oauth2ErrorUnauthorizedClient, err.Error())
+	case err == ErrInvalidGrant, isUnauthorizedError(err):
+		// e.g. an expired, revoked or reused refresh token
+		writeOAuth2Error(w, 400, oauth2ErrorInvalidGrant, err.Error())
+	default:
+		a.errorHandler(w, r, err)
+	}
+}
+
+func writeOAuth2Error(w http.ResponseWriter, status int, code string, description string) {
+	writeOAuth2JSON(w, status, oauth2ErrorResponse{Error: code, ErrorDescription: description})
+}
+
+func writeOAuth2JSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	// responses with tokens mustn't be cached
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,226 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// testAuthenticator knows the "app" and "other" clients, whose secret is "secret", and bob,
+// whose password is "password"
+type testAuthenticator struct{}
+
+func (testAuthenticator) AuthenticateClient(r *http.Request, clientId string, clientSecret string) error {
+	if (clientId != "app" && clientId != "other") || clientSecret != "secret" {
+		return jwt.ErrInvalidClient
+	}
+	return nil
+}
+
+func (testAuthenticator) AuthenticateUser(r *http.Request, clientId string, username string, password string) (jwt.Claims, error) {
+	if username != "bob" || password != "password" {
+		return nil, jwt.ErrInvalidGrant
+	}
+	claims := bob
+	claims.Scope = r.FormValue("scope")
+	return &claims, nil
+}
+
+func (testAuthenticator) ClientClaims(r *http.Request, clientId string) (jwt.Claims, error) {
+	return &jwt.ClaimsType{Scope: r.FormValue("scope")}, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	IdToken      string `json:"id_token"`
+	Error        string `json:"error"`
+}
+
+// newOAuth2Harness is a harness whose Auth serves OAuth2 clients
+func newOAuth2Harness(t *testing.T, options ...jwt.Options) *jwttest.Harness {
+	h := jwttest.New(t, options...)
+	h.Auth.SetOAuth2Authenticator(testAuthenticator{})
+	return h
+}
+
+// postForm posts the form to the handler as the client
+func postForm(handler http.Handler, clientId string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientId, "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// grant posts the token request as the client, and decodes the response
+func grant(t *testing.T, h *jwttest.Harness, clientId string, form url.Values) (int, tokenResponse) {
+	t.Helper()
+
+	w := postForm(h.Auth.TokenEndpoint(), clientId, form)
+	var response tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, response
+}
+
+func passwordGrant(t *testing.T, h *jwttest.Harness) tokenResponse {
+	t.Helper()
+
+	code, response := grant(t, h, "app", url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"password"}})
+	if code != 200 || response.AccessToken == "" || response.RefreshToken == "" {
+		t.Fatalf("password grant: code = %d, response = %+v", code, response)
+	}
+	return response
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	for name, options := range map[string]jwt.Options{
+		"default":               {AuthorizationHeader: true},
+		"rotated refresh token": {AuthorizationHeader: true, RotateRefreshTokens: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newOAuth2Harness(t, options)
+			tokens := passwordGrant(t, h)
+
+			// an access token shares its id and csrf secret with its refresh token, but isn't one
+			code, response := grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.AccessToken}})
+			if code != 400 || response.Error != "invalid_grant" {
+				t.Errorf("access token as the refresh_token: code = %d, response = %+v", code, response)
+			}
+
+			// refresh tokens are bound to their client
+			code, response = grant(t, h, "other", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+			if code != 400 || response.Error != "invalid_grant" {
+				t.Errorf("another client's refresh token: code = %d, response = %+v", code, response)
+			}
+
+			code, response = grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+			if code != 200 || response.AccessToken == "" || response.AccessToken == tokens.AccessToken {
+				t.Fatalf("refresh: code = %d, response = %+v", code, response)
+			}
+			if w := h.Serve(okHandler, h.NewRequest("GET", "/", jwttest.Tokens{AuthToken: response.AccessToken})); w.Code != 200 {
+				t.Errorf("refreshed access token code = %d", w.Code)
+			}
+
+			// a rotated refresh token can't be used again, once retries of the refresh are no longer
+			// answered with the same tokens. Otherwise it's still valid.
+			h.Clock.Advance(time.Minute)
+			code, response = grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+			if options.RotateRefreshTokens && (code != 400 || response.Error != "invalid_grant") {
+				t.Errorf("rotated refresh token: code = %d, response = %+v", code, response)
+			} else if !options.RotateRefreshTokens && code != 200 {
+				t.Errorf("used refresh token: code = %d, response = %+v", code, response)
+			}
+		})
+	}
+}
+
+func TestPasswordGrant(t *testing.T) {
+	h := newOAuth2Harness(t, jwt.Options{AuthorizationHeader: true})
+
+	code, response := grant(t, h, "app", url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"wrong"}})
+	if code != 400 || response.Error != "invalid_grant" {
+		t.Errorf("wrong password: code = %d, response = %+v", code, response)
+	}
+
+	w := postForm(h.Auth.TokenEndpoint(), "unknown", url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"password"}})
+	if w.Code != 401 || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("unknown client: code = %d", w.Code)
+	}
+
+	code, response = grant(t, h, "app", url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"password"}, "scope": {"read write"}})
+	if code != 200 || response.Scope != "read write" {
+		t.Fatalf("code = %d, response = %+v", code, response)
+	}
+	if scope := tokenScope(t, h, response.AccessToken); scope != "read write" {
+		t.Errorf("access token scope = %q", scope)
+	}
+	refreshToken := response.RefreshToken
+
+	// the scope can't be widened when the tokens are refreshed
+	code, response = grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "scope": {"read write admin"}})
+	if code != 400 || response.Error != "invalid_scope" {
+		t.Errorf("widened scope: code = %d, response = %+v", code, response)
+	}
+
+	// but it can be narrowed, for the access token only
+	code, response = grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "scope": {"read"}})
+	if code != 200 || response.Scope != "read" {
+		t.Fatalf("narrowed scope: code = %d, response = %+v", code, response)
+	}
+	if scope := tokenScope(t, h, response.AccessToken); scope != "read" {
+		t.Errorf("narrowed access token scope = %q", scope)
+	}
+	code, response = grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {response.RefreshToken}})
+	if code != 200 || response.Scope != "read write" {
+		t.Errorf("refresh token scope: code = %d, response = %+v", code, response)
+	}
+}
+
+// tokenScope returns the scope of the access token, as the middleware sees it
+func tokenScope(t *testing.T, h *jwttest.Harness, accessToken string) string {
+	t.Helper()
+
+	scope := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := h.Auth.GrabTokenClaims(w, r)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(claims.Scope))
+	})
+	w := h.Serve(scope, h.NewRequest("GET", "/", jwttest.Tokens{AuthToken: accessToken}))
+	if w.Code != 200 {
+		t.Errorf("access token code = %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+// noClaimsAuthenticator is a broken authenticator that lets bob in without any claims
+type noClaimsAuthenticator struct {
+	testAuthenticator
+}
+
+func (noClaimsAuthenticator) AuthenticateUser(r *http.Request, clientId string, username string, password string) (jwt.Claims, error) {
+	return nil, nil
+}
+
+func TestPasswordGrantWithoutClaims(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{AuthorizationHeader: true})
+	h.Auth.SetOAuth2Authenticator(noClaimsAuthenticator{})
+
+	code, response := grant(t, h, "app", url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"password"}})
+	if code != 400 || response.Error != "invalid_grant" || response.AccessToken != "" {
+		t.Errorf("code = %d, response = %+v", code, response)
+	}
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	h := newOAuth2Harness(t, jwt.Options{AuthorizationHeader: true})
+
+	code, response := grant(t, h, "app", url.Values{"grant_type": {"client_credentials"}, "scope": {"read"}})
+	if code != 200 || response.AccessToken == "" || response.RefreshToken != "" || response.Scope != "read" {
+		t.Fatalf("code = %d, response = %+v", code, response)
+	}
+
+	subject := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := h.Auth.GrabTokenClaims(w, r)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(claims.StandardClaims.Subject))
+	})
+	if w := h.Serve(subject, h.NewRequest("GET", "/", jwttest.Tokens{AuthToken: response.AccessToken})); w.Code != 200 || w.Body.String() != "app" {
+		t.Errorf("code = %d, subject = %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,198 @@
+package jwt
+
+import (
+	"crypto"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// the scope that clients request id tokens with, and the scopes that release the user's profile
+// https://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims
+const (
+	ScopeOpenId  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// UserInfo holds the claims about a user that go in their id tokens (see SetUserInfoProvider)
+type UserInfo struct {
+	// Subject defaults to the subject of the auth token
+	Subject string
+	// Email and EmailVerified are only released with the "email" scope
+	Email         string
+	EmailVerified bool
+	// Name is only released with the "profile" scope
+	Name string
+}
+
+// UserInfoProvider looks up the user that tokens are being issued to, for their id token.
+// claims are the claims of the auth token that is issued along with it.
+type UserInfoProvider func(r *http.Request, claims Claims) (UserInfo, error)
+
+// SetUserInfoProvider registers the callback that fills in the sub, email and name claims of
+// id tokens. Without one, id tokens only carry the auth token's subject.
+func (a *Auth) SetUserInfoProvider(provider UserInfoProvider) {
+	a.userInfoProvider = provider
+}
+
+// idTokenClaims are the claims of an OpenID Connect id token
+// https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type idTokenClaims struct {
+	jwtGo.StandardClaims
+	AuthTime      int64  `json:"auth_time,omitempty"`
+	Nonce         string `json:"nonce,omitempty"`
+	AtHash        string `json:"at_hash,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+// createIdTokenString issues an id token to the client that the auth token was issued to.
+// The nonce is the one the client sent with its authorization request, if any.
+func (a *Auth) createIdTokenString(r *http.Request, claims Claims, authTokenString string, nonce string) (string, error) {
+	if a.options.Issuer == "" {
+		return "", ErrNoIssuer
+	}
+	base := claims.Base()
+
+	userInfo := UserInfo{}
+	if a.userInfoProvider != nil {
+		var err error
+		userInfo, err = a.userInfoProvider(r, claims)
+		if err != nil {
+			return "", err
+		}
+	}
+	if userInfo.Subject == "" {
+		userInfo.Subject = base.Subject
+	}
+
+	now := a.now()
+	idClaims := idTokenClaims{
+		StandardClaims: jwtGo.StandardClaims{
+			Issuer:    a.options.Issuer,
+			Subject:   userInfo.Subject,
+			Audience:  base.ClientId,
+			ExpiresAt: now.Add(a.options.AuthTokenValidTime).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		AuthTime: base.AuthTime,
+		Nonce:    nonce,
+		AtHash:   tokenHash(a.options.SigningMethodString, authTokenString),
+	}
+	if base.HasScope(ScopeEmail) {
+		idClaims.Email = userInfo.Email
+		idClaims.EmailVerified = userInfo.EmailVerified
+	}
+	if base.HasScope(ScopeProfile) {
+		idClaims.Name = userInfo.Name
+	}
+
+	idJwt := jwtGo.NewWithClaims(jwtGo.GetSigningMethod(a.options.SigningMethodString), idClaims)
+	return a.signToken(idJwt)
+}
+
+// tokenHash is the left half of the token's hash, base64url encoded, as used by at_hash.
+// The hash is the one the signing method uses.
+// https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken
+func tokenHash(signingMethodString string, token string) string {
+	hash := crypto.SHA256
+	switch signingMethodString {
+	case "HS384", "RS384", "ES384", "PS384":
+		hash = crypto.SHA384
+	case "HS512", "RS512", "ES512", "PS512", "EdDSA":
+		hash = crypto.SHA512
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(token))
+	sum := hasher.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// OpenIDEndpoints are the urls that the provider's endpoints are served at, for the discovery
+// document (see OpenIDConfigurationHandler). Paths, e.g. "/oauth2/token", are relative to the
+// Issuer option. Endpoints that aren't served are left empty.
+type OpenIDEndpoints struct {
+	Authorization string
+	Token         string
+	JWKS          string
+	Introspection string
+	Revocation    string
+}
+
+// openIDConfiguration is the provider's discovery document
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfigurationHandler serves the OpenID Connect discovery document, which is meant to be
+// served at "/.well-known/openid-configuration" under the Issuer option. It lists the endpoints,
+// and the signing method that tokens and id tokens are signed with.
+func (a *Auth) OpenIDConfigurationHandler(endpoints OpenIDEndpoints) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		if a.options.Issuer == "" {
+			a.myLog(ErrNoIssuer)
+			a.errorHandler(w, r, ErrNoIssuer)
+			return
+		}
+
+		config := openIDConfiguration{
+			Issuer:                            a.options.Issuer,
+			AuthorizationEndpoint:             a.issuerURL(endpoints.Authorization),
+			TokenEndpoint:                     a.issuerURL(endpoints.Token),
+			JWKSURI:                           a.issuerURL(endpoints.JWKS),
+			IntrospectionEndpoint:             a.issuerURL(endpoints.Introspection),
+			RevocationEndpoint:                a.issuerURL(endpoints.Revocation),
+			ResponseTypesSupported:            []string{"code"},
+			SubjectTypesSupported:             []string{"public"},
+			IdTokenSigningAlgValuesSupported:  []string{a.options.SigningMethodString},
+			GrantTypesSupported:               []string{GrantTypePassword, GrantTypeRefreshToken, GrantTypeClientCredentials},
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+			ScopesSupported:                   []string{ScopeOpenId, ScopeProfile, ScopeEmail},
+			ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email", "email_verified", "name"},
+		}
+
+		if endpoints.Authorization != "" {
+			config.GrantTypesSupported = append(config.GrantTypesSupported, GrantTypeAuthorizationCode)
+			config.CodeChallengeMethodsSupported = []string{codeChallengeMethodS256}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwksMaxAge.Seconds())))
+		json.NewEncoder(w).Encode(config)
+	})
+}
+
+// issuerURL resolves an endpoint's path against the Issuer option
+func (a *Auth) issuerURL(endpoint string) string {
+	if !strings.HasPrefix(endpoint, "/") {
+		return endpoint
+	}
+	return strings.TrimSuffix(a.options.Issuer, "/") + endpoint
+}
@@ -0,0 +1,150 @@
+package jwt_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+const issuer = "https://auth.example.com"
+
+type openIDConfiguration struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	ResponseTypesSupported        []string `json:"response_types_supported"`
+	GrantTypesSupported           []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+func discover(t *testing.T, h *jwttest.Harness, endpoints jwt.OpenIDEndpoints) openIDConfiguration {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.Auth.OpenIDConfigurationHandler(endpoints).ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	var config openIDConfiguration
+	if err := json.NewDecoder(w.Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{Issuer: issuer})
+
+	config := discover(t, h, jwt.OpenIDEndpoints{Token: "/oauth2/token", JWKS: "https://keys.example.com/jwks"})
+	if config.Issuer != issuer || config.TokenEndpoint != issuer+"/oauth2/token" || config.JWKSURI != "https://keys.example.com/jwks" {
+		t.Errorf("config = %+v", config)
+	}
+	// response_types_supported is required, even without an authorization endpoint
+	if !reflect.DeepEqual(config.ResponseTypesSupported, []string{"code"}) {
+		t.Errorf("response types = %v", config.ResponseTypesSupported)
+	}
+	if config.AuthorizationEndpoint != "" || stringInSlice("authorization_code", config.GrantTypesSupported) || config.CodeChallengeMethodsSupported != nil {
+		t.Errorf("the authorization code grant is advertised: %+v", config)
+	}
+
+	config = discover(t, h, jwt.OpenIDEndpoints{Authorization: "/oauth2/authorize", Token: "/oauth2/token"})
+	if config.AuthorizationEndpoint != issuer+"/oauth2/authorize" || !stringInSlice("authorization_code", config.GrantTypesSupported) {
+		t.Errorf("config = %+v", config)
+	}
+	if !reflect.DeepEqual(config.ResponseTypesSupported, []string{"code"}) || !reflect.DeepEqual(config.CodeChallengeMethodsSupported, []string{"S256"}) {
+		t.Errorf("config = %+v", config)
+	}
+
+	// the document is meaningless without an issuer
+	h = jwttest.New(t)
+	w := httptest.NewRecorder()
+	h.Auth.OpenIDConfigurationHandler(jwt.OpenIDEndpoints{}).ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+	if w.Code == 200 {
+		t.Error("the document was served without an issuer")
+	}
+}
+
+type idTokenClaims struct {
+	jwtGo.StandardClaims
+	Nonce  string `json:"nonce"`
+	AtHash string `json:"at_hash"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+}
+
+func parseIdToken(t *testing.T, h *jwttest.Harness, idToken string) idTokenClaims {
+	t.Helper()
+
+	var claims idTokenClaims
+	if _, err := jwtGo.ParseWithClaims(idToken, &claims, func(token *jwtGo.Token) (interface{}, error) {
+		return h.Options.HMACKey, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestIdToken(t *testing.T) {
+	h := newOAuth2Harness(t, jwt.Options{AuthorizationHeader: true, Issuer: issuer})
+	if err := h.Auth.RegisterRedirectURIs("app", redirectURI); err != nil {
+		t.Fatal(err)
+	}
+	h.Auth.SetUserInfoProvider(func(r *http.Request, claims jwt.Claims) (jwt.UserInfo, error) {
+		return jwt.UserInfo{Email: "bob@example.com", Name: "Bob"}, nil
+	})
+
+	code := authorize(t, h, url.Values{"scope": {"openid email"}, "nonce": {"n-0S6_WzA2Mj"}}).Query().Get("code")
+	status, response := grant(t, h, "app", url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {codeVerifier}})
+	if status != 200 || response.IdToken == "" {
+		t.Fatalf("code = %d, response = %+v", status, response)
+	}
+
+	claims := parseIdToken(t, h, response.IdToken)
+	if claims.Issuer != issuer || claims.Subject != "bob" || claims.Audience != "app" || claims.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("claims = %+v", claims)
+	}
+	// the left half of the access token's sha256 hash
+	hash := sha256.Sum256([]byte(response.AccessToken))
+	if claims.AtHash != base64.RawURLEncoding.EncodeToString(hash[:16]) {
+		t.Errorf("at_hash = %q", claims.AtHash)
+	}
+	// the name is only released with the profile scope
+	if claims.Email != "bob@example.com" || claims.Name != "" {
+		t.Errorf("email = %q, name = %q", claims.Email, claims.Name)
+	}
+
+	// no id token without the openid scope
+	code = authorize(t, h, url.Values{"scope": {"email"}}).Query().Get("code")
+	status, response = grant(t, h, "app", url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {codeVerifier}})
+	if status != 200 || response.IdToken != "" {
+		t.Errorf("code = %d, response = %+v", status, response)
+	}
+
+	// the password grant has no nonce
+	status, response = grant(t, h, "app", url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"password"}, "scope": {"openid"}})
+	if status != 200 || response.IdToken == "" {
+		t.Fatalf("code = %d, response = %+v", status, response)
+	}
+	if claims := parseIdToken(t, h, response.IdToken); claims.Audience != "app" || claims.Nonce != "" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
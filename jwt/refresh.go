@@ -0,0 +1,163 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adam-hanna/randomstrings"
+)
+
+// RefreshHandler returns a handler that exchanges a refresh token for a new auth token, csrf secret
+// and refresh token. The refresh token is rotated if RotateRefreshTokens is set.
+// It is meant to be used with the RefreshEndpoint option, so that the long lived refresh token is
+// only ever sent to this endpoint. Clients should POST to it when their auth token has expired.
+//
+// The refresh token is read the same way as by the middleware (from the refresh cookie, the
+// Refresh_Token json / form value, or the Refresh-Token header). With cookies, the request's csrf
+// secret has to match the refresh token's.
+func (a *Auth) RefreshHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		refreshTokenValue, err := a.grabRefreshTokenFromReq(r)
+		if err != nil {
+			a.myLog(err)
+			a.errorHandler(w, r, err)
+			return
+		}
+
+		authTokenString, refreshTokenString, csrfSecret, claims, err := a.refreshTokens(refreshTokenValue, a.checkRefreshCsrf(grabCsrfFromReq(r)))
+		if err == nil {
+			err = a.recordSession(r, claims)
+		}
+		if err != nil {
+			if isUnauthorizedError(err) {
+				a.myLog("Unauthorized refresh attempt!")
+				a.myLog(err)
+
+				a.setBearerChallenge(w, bearerErrorInvalidToken, err.Error())
+				a.unauthorizedHandler(w, r, err)
+			} else {
+				a.myLog(err)
+				a.errorHandler(w, r, err)
+			}
+			return
+		}
+
+		a.myLog("Successfully refreshed jwts")
+
+		a.setAuthAndRefreshTokens(&w, authTokenString, refreshTokenString)
+		w.Header().Set("X-CSRF-Token", csrfSecret)
+		w.Header().Set("Auth-Expiry", strconv.FormatInt(a.now().Add(a.options.AuthTokenValidTime).Unix(), 10))
+		w.Header().Set("Refresh-Expiry", strconv.FormatInt(a.now().Add(a.options.RefreshTokenValidTime).Unix(), 10))
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// grabRefreshTokenFromReq reads the refresh token from wherever the transport mode puts it.
+// A missing refresh token is returned as an empty string.
+func (a *Auth) grabRefreshTokenFromReq(r *http.Request) (string, error) {
+	_, refreshTokenValue, err := a.grabTokensFromReq(r)
+	return refreshTokenValue, err
+}
+
+// grabTokensFromReq reads the auth and refresh tokens from wherever the transport mode puts them.
+// Missing tokens are returned as empty strings.
+func (a *Auth) grabTokensFromReq(r *http.Request) (authTokenValue string, refreshTokenValue string, err error) {
+	if a.options.AuthorizationHeader {
+		return grabBearerTokenFromReq(r), r.Header.Get(refreshTokenHeader), nil
+
+	} else if a.options.BearerTokens {
+		if r.Header.Get("Content-Type") == "application/json" {
+			content, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return "", "", err
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(content))
+
+			var bearerTokens bearerTokensStruct
+			if err := json.Unmarshal(content, &bearerTokens); err != nil {
+				return "", "", err
+			}
+			return bearerTokens.Auth_Token, bearerTokens.Refresh_Token, nil
+		}
+
+		r.ParseForm()
+		return strings.Join(r.Form["Auth_Token"], ""), strings.Join(r.Form["Refresh_Token"], ""), nil
+	}
+
+	if authCookie, err := r.Cookie(a.authCookieName()); err == nil {
+		authTokenValue = authCookie.Value
+	}
+	if refreshCookie, err := r.Cookie(a.refreshCookieName()); err == nil {
+		refreshTokenValue = refreshCookie.Value
+	}
+	return authTokenValue, refreshTokenValue, nil
+}
+
+// refreshTokens checks the refresh token and issues new tokens with a new csrf secret.
+// check, if not nil, is called with the verified refresh token's claims before anything is issued.
+func (a *Auth) refreshTokens(oldRefreshTokenString string, check func(claims Claims) error) (newAuthTokenString, newRefreshTokenString, csrfSecret string, claims Claims, err error) {
+	if a.options.VerifyOnlyServer {
+		a.myLog("Server is not authorized to issue new tokens")
+		err = ErrVerifyOnly
+		return
+	}
+
+	if a.options.RotateRefreshTokens {
+		return a.rotateRefreshToken(oldRefreshTokenString, check)
+	}
+
+	claims, err = a.checkRefreshToken(oldRefreshTokenString)
+	if err != nil {
+		return
+	}
+
+	if check != nil {
+		if err = check(claims); err != nil {
+			return
+		}
+	}
+
+	// our policy is to regenerate the csrf secret for each new auth token
+	csrfSecret, err = randomstrings.GenerateRandomString(32)
+	if err != nil {
+		return
+	}
+
+	newRefreshTokenString, err = a.createRefreshTokenString(claims, csrfSecret)
+	if err != nil {
+		return
+	}
+
+	newAuthTokenString, err = a.createAuthTokenString(claims, csrfSecret)
+	return
+}
+
+// checkRefreshCsrf returns a check for refreshTokens that the csrf secret matches the refresh token's.
+// The auth cookie has usually expired by the time tokens are refreshed, so it can't be checked against
+// the auth token.
+func (a *Auth) checkRefreshCsrf(csrfSecret string) func(claims Claims) error {
+	return func(claims Claims) error {
+		if a.options.AuthorizationHeader {
+			return nil
+		}
+		if csrfSecret == "" {
+			a.myLog("No CSRF token in request!")
+			return ErrNoCsrfToken
+		}
+		if csrfSecret != claims.Base().Csrf {
+			a.myLog("CSRF token doesn't match jwt!")
+			return ErrCsrfMismatch
+		}
+		return nil
+	}
+}
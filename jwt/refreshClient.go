@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultRefreshTimeout = 10 * time.Second
+
+// refreshClient calls the auth server's refresh endpoint on behalf of a verify only server
+type refreshClient struct {
+	url    string
+	client *http.Client
+}
+
+func newRefreshClient(refreshURL string) *refreshClient {
+	return &refreshClient{
+		url:    refreshURL,
+		client: &http.Client{Timeout: defaultRefreshTimeout},
+	}
+}
+
+// refreshFromAuthServer sends the refresh token (and csrf secret) to the auth server's RefreshHandler,
+// the same way a client would, and verifies the auth token that it returns.
+// The request is cancelled if the client's request is.
+func (a *Auth) refreshFromAuthServer(r *http.Request, refreshTokenString string, csrfSecret string) (newAuthTokenString, newRefreshTokenString, newCsrfSecret string, claims Claims, err error) {
+	if refreshTokenString == "" {
+		a.myLog("No refresh token!")
+		err = ErrNoRefreshToken
+		return
+	}
+
+	var req *http.Request
+	if a.options.AuthorizationHeader {
+		req, err = http.NewRequest("POST", a.refreshClient.url, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set(refreshTokenHeader, refreshTokenString)
+
+	} else if a.options.BearerTokens {
+		form := url.Values{"Refresh_Token": {refreshTokenString}}
+		req, err = http.NewRequest("POST", a.refreshClient.url, strings.NewReader(form.Encode()))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-CSRF-Token", csrfSecret)
+
+	} else {
+		req, err = http.NewRequest("POST", a.refreshClient.url, nil)
+		if err != nil {
+			return
+		}
+		req.AddCookie(&http.Cookie{Name: a.refreshCookieName(), Value: refreshTokenString})
+		req.Header.Set("X-CSRF-Token", csrfSecret)
+	}
+
+	a.myLog("Asking the auth server to refresh the tokens")
+	resp, err := a.refreshClient.client.Do(req.WithContext(r.Context()))
+	if err != nil {
+		a.myLog(err)
+		err = ErrRefreshUnavailable
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 4 {
+		a.myLog("Auth server refused to refresh the tokens: " + resp.Status)
+		err = ErrRefreshDenied
+		return
+	} else if resp.StatusCode != 200 {
+		a.myLog("Auth server failed to refresh the tokens: " + resp.Status)
+		err = ErrRefreshUnavailable
+		return
+	}
+
+	if a.options.AuthorizationHeader {
+		newAuthTokenString = resp.Header.Get(authTokenHeader)
+		newRefreshTokenString = resp.Header.Get(refreshTokenHeader)
+	} else if a.options.BearerTokens {
+		newAuthTokenString = resp.Header.Get("Auth_Token")
+		newRefreshTokenString = resp.Header.Get("Refresh_Token")
+	} else {
+		for _, cookie := range resp.Cookies() {
+			switch cookie.Name {
+			case a.authCookieName():
+				newAuthTokenString = cookie.Value
+			case a.refreshCookieName():
+				newRefreshTokenString = cookie.Value
+			}
+		}
+	}
+	newCsrfSecret = resp.Header.Get("X-CSRF-Token")
+
+	if newAuthTokenString == "" {
+		a.myLog("Auth server didn't return an auth token")
+		err = ErrRefreshUnavailable
+		return
+	}
+	if newRefreshTokenString == "" {
+		newRefreshTokenString = refreshTokenString
+	}
+
+	// don't just trust the auth server's response
+	claims, err = a.parseAuthToken(r.Context(), newAuthTokenString)
+	return
+}
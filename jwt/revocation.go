@@ -0,0 +1,121 @@
+package jwt
+
+import (
+	"net/http"
+)
+
+// RevokeToken revokes an auth or refresh token, e.g. from admin tooling. An auth token shares its
+// id with its refresh token, so either one revokes the whole login: the refresh token and its family
+// are revoked, and its session is removed (see SetSessionStore). Auth tokens stay valid until they
+// expire, except to the IntrospectionEndpoint.
+// An expired auth token still revokes its refresh token, which lives longer. Tokens from the
+// client_credentials grant have no refresh token, so ErrTokenNotRevocable is returned for them.
+func (a *Auth) RevokeToken(tokenString string) error {
+	claims, tokenType, err := a.parseTokenType(tokenString)
+	if err != nil && err != ErrTokenExpired {
+		return err
+	}
+
+	if !revocable(claims, tokenType) {
+		a.myLog("Token to revoke has no refresh token!")
+		return ErrTokenNotRevocable
+	}
+
+	return a.revokeTokenClaims(claims)
+}
+
+// RevocationEndpoint returns an OAuth2 token revocation endpoint (https://tools.ietf.org/html/rfc7009).
+// Clients authenticate like they do at the TokenEndpoint (see SetOAuth2Authenticator), and POST
+// the token in the "token" form value. A "token_type_hint" isn't needed; auth and refresh tokens
+// are told apart by their typ header. Tokens are revoked like RevokeToken revokes them, but only
+// if they were issued to the client. As the RFC requires, the response is a 200 even if the
+// token is invalid, has already been revoked, was issued to another client, or is from the
+// client_credentials grant, which can't be revoked. Only a malformed request or a failed client
+// authentication gets an error.
+func (a *Auth) RevocationEndpoint() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+
+		if a.options.VerifyOnlyServer {
+			a.myLog("Server can't revoke tokens")
+			a.errorHandler(w, r, ErrVerifyOnly)
+			return
+		}
+		clientId, _, ok := a.authenticateOAuth2Client(w, r)
+		if !ok {
+			return
+		}
+
+		tokenString := r.PostForm.Get("token")
+		if tokenString == "" {
+			writeOAuth2Error(w, 400, oauth2ErrorInvalidRequest, "No token")
+			return
+		}
+
+		// an expired auth token still identifies its refresh token
+		claims, tokenType, err := a.parseTokenType(tokenString)
+		if err != nil && err != ErrTokenExpired {
+			// invalid tokens don't need to be revoked
+			// https://tools.ietf.org/html/rfc7009#section-2.2
+			a.myLog("Token to revoke is not valid")
+			a.myLog(err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// neither is revoked, but the response is the same, so that it tells the client nothing
+		// about the token
+		if claims.Base().ClientId != clientId {
+			a.myLog("Token to revoke was issued to another client!")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !revocable(claims, tokenType) {
+			a.myLog("Token to revoke has no refresh token!")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if err := a.revokeTokenClaims(claims); err != nil {
+			a.myLog(err)
+			a.errorHandler(w, r, err)
+			return
+		}
+
+		a.myLog("Revoked " + tokenType)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// revocable is false for auth tokens from the client_credentials grant, which have no refresh
+// token or family. Every other auth token belongs to a family.
+func revocable(claims Claims, tokenType string) bool {
+	return tokenType == TokenTypeHintRefreshToken || claims.Base().FamilyId != ""
+}
+
+// revokeTokenClaims revokes the refresh token with the claims' token id, its family and its session
+func (a *Auth) revokeTokenClaims(claims Claims) error {
+	base := claims.Base()
+
+	if err := a.revokeRefreshToken(base.StandardClaims.Id); err != nil {
+		return err
+	}
+
+	if base.FamilyId == "" {
+		return nil
+	}
+	if err := a.revokeTokenFamily(base.FamilyId); err != nil {
+		return err
+	}
+	if a.sessionStore != nil {
+		if err := a.sessionStore.RemoveSession(base.FamilyId); err != nil && err != ErrSessionNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package jwt_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+)
+
+func TestRevokeExpiredAuthToken(t *testing.T) {
+	h := newOAuth2Harness(t)
+	tokens := passwordGrant(t, h)
+
+	// the auth token has expired, but its refresh token is still live
+	h.Clock.Advance(time.Hour)
+	if err := h.Auth.RevokeToken(tokens.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := grant(t, h, "app", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}); code != 400 {
+		t.Errorf("revoked refresh token code = %d, want 400", code)
+	}
+}
+
+func TestRevocationEndpoint(t *testing.T) {
+	h := newOAuth2Harness(t)
+	tokens := passwordGrant(t, h)
+	refreshGrant := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+
+	// another client's token isn't revoked, but the response doesn't say so
+	if w := postForm(h.Auth.RevocationEndpoint(), "other", url.Values{"token": {tokens.AccessToken}}); w.Code != 200 {
+		t.Errorf("another client's token: code = %d", w.Code)
+	}
+	if code, _ := grant(t, h, "app", refreshGrant); code != 200 {
+		t.Fatalf("refresh code = %d", code)
+	}
+
+	if w := postForm(h.Auth.RevocationEndpoint(), "app", url.Values{}); w.Code != 400 {
+		t.Errorf("no token: code = %d, want 400", w.Code)
+	}
+	if w := postForm(h.Auth.RevocationEndpoint(), "unknown", url.Values{"token": {tokens.AccessToken}}); w.Code != 401 {
+		t.Errorf("unknown client: code = %d, want 401", w.Code)
+	}
+
+	// the refresh token isn't rotated, and is still live once the auth token expires
+	h.Clock.Advance(time.Hour)
+	if w := postForm(h.Auth.RevocationEndpoint(), "app", url.Values{"token": {tokens.AccessToken}}); w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	if code, _ := grant(t, h, "app", refreshGrant); code != 400 {
+		t.Errorf("revoked refresh token code = %d, want 400", code)
+	}
+}
+
+func TestRevokeClientCredentialsToken(t *testing.T) {
+	h := newOAuth2Harness(t)
+	code, response := grant(t, h, "app", url.Values{"grant_type": {"client_credentials"}})
+	if code != 200 {
+		t.Fatalf("code = %d, response = %+v", code, response)
+	}
+
+	if err := h.Auth.RevokeToken(response.AccessToken); err != jwt.ErrTokenNotRevocable {
+		t.Errorf("RevokeToken = %v, want ErrTokenNotRevocable", err)
+	}
+
+	if w := postForm(h.Auth.RevocationEndpoint(), "app", url.Values{"token": {response.AccessToken}}); w.Code != 200 {
+		t.Errorf("revocation endpoint code = %d", w.Code)
+	}
+	if !introspect(t, h, response.AccessToken) {
+		t.Error("client_credentials token isn't active")
+	}
+}
@@ -0,0 +1,145 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// refreshTokenReuseInterval is how long a rotated refresh token can still be presented without
+// counting as reuse. Browsers often send a few requests at once, all carrying the same expired
+// auth token and refresh token, and only the first one rotates it.
+const refreshTokenReuseInterval = 10 * time.Second
+
+// rotatedTokens are the tokens that a refresh token was rotated to
+type rotatedTokens struct {
+	authToken    string
+	refreshToken string
+	csrfSecret   string
+	claims       Claims
+	rotatedAt    time.Time
+}
+
+// rotationCache remembers the tokens that refresh tokens were rotated to, by the old token's id,
+// for refreshTokenReuseInterval. It also serializes the rotations of each refresh token, so that
+// concurrent requests with the same token rotate it once. It's in memory, so it only covers
+// requests to this server.
+type rotationCache struct {
+	mu     sync.Mutex
+	tokens map[string]rotatedTokens
+	locks  map[string]*rotationLock
+}
+
+// rotationLock is held while a refresh token is being rotated. It's removed from the cache once
+// nobody is waiting for it.
+type rotationLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+func newRotationCache() *rotationCache {
+	return &rotationCache{
+		tokens: make(map[string]rotatedTokens),
+		locks:  make(map[string]*rotationLock),
+	}
+}
+
+// lock locks the refresh token id until unlock is called
+func (c *rotationCache) lock(oldTokenId string) (unlock func()) {
+	c.mu.Lock()
+	l, ok := c.locks[oldTokenId]
+	if !ok {
+		l = &rotationLock{}
+		c.locks[oldTokenId] = l
+	}
+	l.waiters++
+	c.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(c.locks, oldTokenId)
+		}
+	}
+}
+
+// add remembers the tokens, and forgets any that have been kept for longer than the interval
+func (c *rotationCache) add(oldTokenId string, tokens rotatedTokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, rotated := range c.tokens {
+		if tokens.rotatedAt.Sub(rotated.rotatedAt) >= refreshTokenReuseInterval {
+			delete(c.tokens, id)
+		}
+	}
+	c.tokens[oldTokenId] = tokens
+}
+
+// get returns the tokens that the refresh token was rotated to, if that was less than the interval ago
+func (c *rotationCache) get(oldTokenId string, now time.Time) (rotatedTokens, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rotated, ok := c.tokens[oldTokenId]
+	if !ok || now.Sub(rotated.rotatedAt) >= refreshTokenReuseInterval {
+		return rotatedTokens{}, false
+	}
+	return rotated, true
+}
+
+// rotateRefreshToken checks the refresh token and rotates it (see rotateTokens).
+// check, if not nil, is called with the verified refresh token's claims before anything is issued.
+// A refresh token that was rotated less than refreshTokenReuseInterval ago isn't treated as reused:
+// its successor is returned instead, as long as that hasn't been revoked.
+// The whole exchange holds the old token's lock, and the successor is cached before the old token
+// is revoked, so a retried request never finds it revoked and uncached, which would count as reuse.
+func (a *Auth) rotateRefreshToken(oldRefreshTokenString string, check func(claims Claims) error) (newAuthTokenString, newRefreshTokenString, csrfSecret string, claims Claims, err error) {
+	claims, err = a.verifyRefreshToken(oldRefreshTokenString)
+	if err != nil {
+		return
+	}
+	oldTokenId := claims.Base().StandardClaims.Id
+
+	unlock := a.rotations.lock(oldTokenId)
+	defer unlock()
+
+	if rotated, ok := a.rotations.get(oldTokenId, a.now()); ok && a.checkTokenId(rotated.claims.Base().StandardClaims.Id) {
+		if check != nil {
+			if err = check(claims); err != nil {
+				return
+			}
+		}
+		a.myLog("Refresh token was rotated moments ago, returning its successor")
+		return rotated.authToken, rotated.refreshToken, rotated.csrfSecret, rotated.claims, nil
+	}
+
+	if err = a.checkRefreshTokenId(claims); err != nil {
+		return
+	}
+	if check != nil {
+		if err = check(claims); err != nil {
+			return
+		}
+	}
+
+	newAuthTokenString, newRefreshTokenString, csrfSecret, err = a.rotateTokens(claims)
+	if err != nil {
+		return
+	}
+
+	a.rotations.add(oldTokenId, rotatedTokens{
+		authToken:    newAuthTokenString,
+		refreshToken: newRefreshTokenString,
+		csrfSecret:   csrfSecret,
+		claims:       claims,
+		rotatedAt:    a.now(),
+	})
+
+	err = a.revokeRefreshToken(oldTokenId)
+	return
+}
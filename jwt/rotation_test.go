@@ -0,0 +1,155 @@
+package jwt_test
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+func TestRotateRefreshTokens(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true})
+	tokens := h.ExpireAuthToken(h.Issue(bob))
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	rotated := h.AssertTokensSet(w)
+	if rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatal("refresh token wasn't rotated")
+	}
+
+	stored, _ := h.Store.ListTokensBySubject("bob")
+	if len(stored) != 1 || stored[0].FamilyId == stored[0].Id {
+		t.Fatalf("stored tokens = %+v", stored)
+	}
+
+	// the rotated token can be used in turn
+	h.Clock.Advance(time.Hour)
+	w = h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(rotated)))
+	if w.Code != 200 {
+		t.Errorf("code = %d", w.Code)
+	}
+}
+
+func TestRotatedRefreshTokenReuseInterval(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true})
+	tokens := h.ExpireAuthToken(h.Issue(bob))
+
+	// two requests that were sent at the same time, with the same tokens
+	first := h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	h.Clock.Advance(time.Second)
+	second := h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	if first.Code != 200 || second.Code != 200 {
+		t.Fatalf("codes = %d, %d", first.Code, second.Code)
+	}
+
+	firstTokens, secondTokens := h.AssertTokensSet(first), h.AssertTokensSet(second)
+	if firstTokens != secondTokens {
+		t.Error("the second request didn't get the tokens that the refresh token was rotated to")
+	}
+	if stored, _ := h.Store.ListTokensBySubject("bob"); len(stored) != 1 {
+		t.Errorf("stored tokens = %+v", stored)
+	}
+}
+
+func TestRotatedRefreshTokenReuse(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true})
+	tokens := h.ExpireAuthToken(h.Issue(bob))
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	rotated := h.AssertTokensSet(w)
+
+	// long after it was rotated, the old refresh token may have been stolen
+	h.Clock.Advance(time.Minute)
+	w = h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	if w.Code != 401 {
+		t.Fatalf("reused token code = %d, want 401", w.Code)
+	}
+
+	// so its whole family is revoked
+	w = h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(rotated)))
+	if w.Code != 401 {
+		t.Errorf("rotated token code = %d, want 401", w.Code)
+	}
+	if stored, _ := h.Store.ListTokensBySubject("bob"); len(stored) != 0 {
+		t.Errorf("stored tokens = %+v", stored)
+	}
+}
+
+func TestRotatedRefreshTokenAfterLogout(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true})
+	tokens := h.ExpireAuthToken(h.Issue(bob))
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	rotated := h.AssertTokensSet(w)
+	h.Serve(logoutHandler(h), h.NewRequest("POST", "/logout", rotated))
+
+	// the reuse interval doesn't bring logged out tokens back
+	w = h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+	if w.Code != 401 {
+		t.Errorf("code = %d, want 401", w.Code)
+	}
+}
+
+func TestRefreshHandlerReuseInterval(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true, AuthorizationHeader: true, RefreshEndpoint: true})
+	tokens := h.Issue(bob)
+
+	first := refresh(h, tokens)
+	second := refresh(h, tokens)
+	if first.Code != 200 || second.Code != 200 {
+		t.Fatalf("codes = %d, %d", first.Code, second.Code)
+	}
+	if h.AssertTokensSet(first) != h.AssertTokensSet(second) {
+		t.Error("the second request didn't get the tokens that the refresh token was rotated to")
+	}
+}
+
+// slowStore takes a while to revoke tokens, like a store on the network would
+type slowStore struct {
+	*jwt.MemoryTokenStore
+}
+
+func (s slowStore) RevokeToken(tokenId string) error {
+	time.Sleep(5 * time.Millisecond)
+	return s.MemoryTokenStore.RevokeToken(tokenId)
+}
+
+func TestConcurrentRefreshTokenRotation(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true})
+	h.Auth.SetTokenStore(slowStore{h.Store})
+	tokens := h.ExpireAuthToken(h.Issue(bob))
+
+	// requests that were sent at the same time, with the same tokens, all get the same successor
+	responses := make([]*httptest.ResponseRecorder, 4)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+		}(i)
+	}
+	wg.Wait()
+
+	var rotated []jwttest.Tokens
+	for _, w := range responses {
+		if w.Code != 200 {
+			t.Fatalf("code = %d", w.Code)
+		}
+		rotated = append(rotated, h.AssertTokensSet(w))
+	}
+	for _, tokens := range rotated[1:] {
+		if tokens != rotated[0] {
+			t.Error("the refresh token was rotated more than once")
+		}
+	}
+	if stored, _ := h.Store.ListTokensBySubject("bob"); len(stored) != 1 {
+		t.Errorf("stored tokens = %+v", stored)
+	}
+}
@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Session describes a login on one device. It lasts as long as the login's refresh tokens,
+// so its id is their family id, which doesn't change when refresh tokens are rotated.
+type Session struct {
+	Id          string
+	Subject     string
+	TokenId     string // the jti of the session's current refresh token
+	CreatedAt   time.Time
+	LastRefresh time.Time
+	ExpiresAt   time.Time
+	UserAgent   string
+	IP          string
+}
+
+// SessionStore keeps track of each subject's sessions (see SetSessionStore)
+type SessionStore interface {
+	// StoreSession creates the session, or replaces the session with the same id
+	StoreSession(session Session) error
+	// GetSession returns ErrSessionNotFound if there is no session with the id
+	GetSession(sessionId string) (Session, error)
+	ListSessionsBySubject(subject string) ([]Session, error)
+	RemoveSession(sessionId string) error
+}
+
+// SetSessionStore registers a store that sessions are recorded in when tokens are issued and
+// refreshed. Revoking a session revokes its refresh token family, so use it along with a token
+// store (see SetTokenStore). A MemoryTokenStore can be used as both. Like a token store, it's
+// given the Options.Clock if it has a SetClock method.
+func (a *Auth) SetSessionStore(store SessionStore) {
+	a.setStoreClock(store)
+	a.sessionStore = store
+}
+
+// ListSessions returns the subject's active sessions
+func (a *Auth) ListSessions(subject string) ([]Session, error) {
+	if a.sessionStore == nil {
+		return nil, ErrNoSessionStore
+	}
+
+	return a.sessionStore.ListSessionsBySubject(subject)
+}
+
+// RevokeSession logs the subject out of one session by revoking its refresh tokens.
+// The session's current auth token stays valid until it expires.
+// ErrSessionNotFound is returned if the session doesn't belong to the subject.
+func (a *Auth) RevokeSession(subject string, sessionId string) error {
+	if a.sessionStore == nil {
+		return ErrNoSessionStore
+	}
+
+	session, err := a.sessionStore.GetSession(sessionId)
+	if err != nil {
+		return err
+	}
+	if session.Subject != subject {
+		return ErrSessionNotFound
+	}
+
+	return a.revokeSession(sessionId)
+}
+
+// RevokeOtherSessions logs the request's subject out of every session except the request's own,
+// e.g. for a "log out all other devices" button. The request must have passed through the middleware.
+func (a *Auth) RevokeOtherSessions(r *http.Request) error {
+	if a.sessionStore == nil {
+		return ErrNoSessionStore
+	}
+
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return ErrNoClaims
+	}
+
+	sessions, err := a.sessionStore.ListSessionsBySubject(claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.Id == claims.FamilyId {
+			continue
+		}
+		if err := a.revokeSession(session.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Auth) revokeSession(sessionId string) error {
+	if err := a.revokeTokenFamily(sessionId); err != nil {
+		return err
+	}
+
+	a.myLog("Session has been revoked")
+	return a.sessionStore.RemoveSession(sessionId)
+}
+
+// recordSession creates or updates the claims' session, if a session store has been set.
+// r may be nil, e.g. when tokens are issued outside of a request.
+func (a *Auth) recordSession(r *http.Request, claims Claims) error {
+	if a.sessionStore == nil {
+		return nil
+	}
+
+	base := claims.Base()
+	now := a.now()
+
+	session, err := a.sessionStore.GetSession(base.FamilyId)
+	if err == ErrSessionNotFound {
+		session = Session{
+			Id:        base.FamilyId,
+			Subject:   base.Subject,
+			CreatedAt: now,
+		}
+	} else if err != nil {
+		return err
+	}
+
+	session.TokenId = base.Id
+	session.LastRefresh = now
+	session.ExpiresAt = now.Add(a.options.RefreshTokenValidTime)
+	if r != nil {
+		session.UserAgent = r.UserAgent()
+		session.IP = remoteIP(r)
+	}
+
+	return a.sessionStore.StoreSession(session)
+}
+
+// remoteIP returns the ip of the request's RemoteAddr. If you're behind a proxy, use a middleware
+// that sets RemoteAddr from the X-Forwarded-For header.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,126 @@
+package jwt_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+)
+
+// issueSession logs bob in from a device with the user agent
+func issueSession(t *testing.T, h *jwttest.Harness, userAgent string) jwttest.Tokens {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+	claims := bob
+	if err := h.Auth.IssueNewSession(w, r, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return h.Update(jwttest.Tokens{}, w)
+}
+
+func onlySession(t *testing.T, h *jwttest.Harness) jwt.Session {
+	t.Helper()
+
+	sessions, err := h.Auth.ListSessions("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	return sessions[0]
+}
+
+func TestSessionIsRecorded(t *testing.T) {
+	h := jwttest.New(t)
+	issueSession(t, h, "phone")
+
+	session := onlySession(t, h)
+	if session.UserAgent != "phone" || session.IP != "192.0.2.1" || !session.CreatedAt.Equal(h.Clock.Now()) {
+		t.Errorf("session = %+v", session)
+	}
+}
+
+func TestSessionIsOnlyUpdatedWhenRefreshed(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{RotateRefreshTokens: true})
+	tokens := issueSession(t, h, "phone")
+	created := onlySession(t, h)
+
+	// the refresh token's expiry is extended, but no auth token is issued
+	h.Clock.Advance(time.Minute)
+	r := h.NewRequest("GET", "/", tokens)
+	r.Header.Set("User-Agent", "laptop")
+	w := h.Serve(okHandler, r)
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	if session := onlySession(t, h); session != created {
+		t.Errorf("session was updated without a refresh: %+v", session)
+	}
+
+	// the auth token has expired, so a new one is issued
+	h.Clock.Advance(time.Hour)
+	w = h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(h.Update(tokens, w))))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	session := onlySession(t, h)
+	if !session.LastRefresh.Equal(h.Clock.Now()) || session.Id != created.Id || session.TokenId == created.TokenId {
+		t.Errorf("refreshed session = %+v", session)
+	}
+	if stored, _ := h.Store.ListTokensBySubject("bob"); len(stored) != 1 || stored[0].Id != session.TokenId {
+		t.Errorf("the session's token id isn't the current refresh token's: %+v", stored)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	h := jwttest.New(t)
+	tokens := issueSession(t, h, "phone")
+	session := onlySession(t, h)
+
+	if err := h.Auth.RevokeSession("alice", session.Id); err != jwt.ErrSessionNotFound {
+		t.Errorf("revoking another subject's session = %v", err)
+	}
+	if err := h.Auth.RevokeSession("bob", session.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions, _ := h.Auth.ListSessions("bob"); len(sessions) != 0 {
+		t.Errorf("sessions = %+v", sessions)
+	}
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens))); w.Code != 401 {
+		t.Errorf("revoked session's refresh code = %d, want 401", w.Code)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	h := jwttest.New(t)
+	phone := issueSession(t, h, "phone")
+	laptop := issueSession(t, h, "laptop")
+
+	logoutOthers := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Auth.RevokeOtherSessions(r); err != nil {
+			t.Error(err)
+		}
+	})
+	w := h.Serve(logoutOthers, h.NewRequest("POST", "/sessions/logout-others", laptop))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+
+	if session := onlySession(t, h); session.UserAgent != "laptop" {
+		t.Errorf("the wrong session was kept: %+v", session)
+	}
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(phone))); w.Code != 401 {
+		t.Errorf("other session's refresh code = %d, want 401", w.Code)
+	}
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(laptop))); w.Code != 200 {
+		t.Errorf("own session's refresh code = %d", w.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// StoredToken describes a refresh token that has been registered with a TokenStore
+type StoredToken struct {
+	Id        string
+	Subject   string
+	FamilyId  string
+	ExpiresAt time.Time
+}
+
+// TokenStore keeps track of issued refresh tokens so that they can be checked and revoked.
+// StoreToken may be called more than once for the same token id (e.g. when the refresh
+// token's expiry is extended) and should replace the previous entry.
+type TokenStore interface {
+	StoreToken(token StoredToken) error
+	// CheckToken returns true if the token id is valid (has not been revoked)
+	CheckToken(tokenId string) bool
+	RevokeToken(tokenId string) error
+	RevokeTokensBySubject(subject string) error
+	// RevokeTokenFamily revokes every token with the given family id
+	RevokeTokenFamily(familyId string) error
+	ListTokensBySubject(subject string) ([]StoredToken, error)
+}
+
+const defaultTokenStoreSweepInterval = time.Minute
+
+// MemoryTokenStore is a concurrency safe, in memory TokenStore. It is also a SessionStore.
+// Expired entries are swept periodically.
+type MemoryTokenStore struct {
+	clock    Clock
+	mu       sync.RWMutex
+	tokens   map[string]StoredToken
+	sessions map[string]Session
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewMemoryTokenStore constructs a new MemoryTokenStore. Expired tokens are removed
+// every sweepInterval (one minute by default). Call Close to stop the sweeper.
+func NewMemoryTokenStore(sweepInterval time.Duration) *MemoryTokenStore {
+	if sweepInterval <= 0 {
+		sweepInterval = defaultTokenStoreSweepInterval
+	}
+
+	s := &MemoryTokenStore{
+		clock:    systemClock{},
+		tokens:   make(map[string]StoredToken),
+		sessions: make(map[string]Session),
+		stop:     make(chan struct{}),
+	}
+	go s.sweep(sweepInterval)
+
+	return s
+}
+
+func (s *MemoryTokenStore) StoreToken(token StoredToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.Id] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) CheckToken(tokenId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[tokenId]
+	return ok && s.clock.Now().Before(token.ExpiresAt)
+}
+
+func (s *MemoryTokenStore) RevokeToken(tokenId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, tokenId)
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeTokensBySubject(subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if token.Subject == subject {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeTokenFamily(familyId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if token.FamilyId == familyId {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) ListTokensBySubject(subject string) ([]StoredToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []StoredToken
+	now := s.clock.Now()
+	for _, token := range s.tokens {
+		if token.Subject == subject && now.Before(token.ExpiresAt) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *MemoryTokenStore) StoreSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.Id] = session
+	return nil
+}
+
+func (s *MemoryTokenStore) GetSession(sessionId string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[sessionId]
+	if !ok || !s.clock.Now().Before(session.ExpiresAt) {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *MemoryTokenStore) ListSessionsBySubject(subject string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []Session
+	now := s.clock.Now()
+	for _, session := range s.sessions {
+		if session.Subject == subject && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *MemoryTokenStore) RemoveSession(sessionId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionId)
+	return nil
+}
+
+// SetClock sets the clock that entries expire by. It should be the same as the Auth's Options.Clock.
+func (s *MemoryTokenStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = clock
+}
+
+// Close stops the background sweeper
+func (s *MemoryTokenStore) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *MemoryTokenStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.removeExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *MemoryTokenStore) removeExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for id, token := range s.tokens {
+		if !now.Before(token.ExpiresAt) {
+			delete(s.tokens, id)
+		}
+	}
+	for id, session := range s.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package jwt_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+var bob = jwt.ClaimsType{StandardClaims: jwtGo.StandardClaims{Subject: "bob"}}
+
+func logoutHandler(h *jwttest.Harness) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.Auth.NullifyTokens(&w, r)
+	})
+}
+
+// refresh posts the tokens to the RefreshHandler
+func refresh(h *jwttest.Harness, tokens jwttest.Tokens) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.Auth.RefreshHandler().ServeHTTP(w, h.NewRefreshRequest(tokens))
+	return w
+}
+
+func TestIssueNewTokensStoresRefreshToken(t *testing.T) {
+	h := jwttest.New(t)
+	h.Issue(bob)
+
+	tokens, err := h.Store.ListTokensBySubject("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Id == "" || tokens[0].FamilyId != tokens[0].Id {
+		t.Fatalf("stored tokens = %+v", tokens)
+	}
+	if !tokens[0].ExpiresAt.Equal(h.Clock.Now().Add(72 * time.Hour).Truncate(time.Second)) {
+		t.Errorf("ExpiresAt = %v", tokens[0].ExpiresAt)
+	}
+}
+
+func TestRevokeTokensBySubject(t *testing.T) {
+	h := jwttest.New(t)
+	tokens := h.Issue(bob)
+
+	if err := h.Store.RevokeTokensBySubject("bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the middleware mustn't store the revoked token again when it extends its expiry
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens)); w.Code != 401 {
+		t.Errorf("code = %d, want 401", w.Code)
+	}
+	if stored, _ := h.Store.ListTokensBySubject("bob"); len(stored) != 0 {
+		t.Errorf("stored tokens = %+v", stored)
+	}
+}
+
+func TestMemoryTokenStoreExpiry(t *testing.T) {
+	clock := jwttest.NewFakeClock(time.Now())
+	store := jwt.NewMemoryTokenStore(time.Hour)
+	defer store.Close()
+	store.SetClock(clock)
+
+	store.StoreToken(jwt.StoredToken{Id: "a", Subject: "bob", ExpiresAt: clock.Now().Add(time.Minute)})
+	if !store.CheckToken("a") {
+		t.Fatal("token isn't valid")
+	}
+
+	clock.Advance(time.Minute)
+	if store.CheckToken("a") {
+		t.Error("expired token is valid")
+	}
+	if tokens, _ := store.ListTokensBySubject("bob"); len(tokens) != 0 {
+		t.Errorf("expired tokens are listed: %+v", tokens)
+	}
+}
+
+func TestNullifyTokensRevokesRefreshToken(t *testing.T) {
+	for name, options := range map[string]jwt.Options{
+		"cookies":              {},
+		"bearer tokens":        {BearerTokens: true},
+		"authorization header": {AuthorizationHeader: true},
+		// the refresh token isn't sent, so the auth token identifies it
+		"refresh endpoint": {AuthorizationHeader: true, RefreshEndpoint: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := jwttest.New(t, options)
+			tokens := h.Issue(bob)
+
+			w := h.Serve(logoutHandler(h), h.NewRequest("POST", "/logout", tokens))
+			if w.Code != 200 {
+				t.Fatalf("logout code = %d", w.Code)
+			}
+			h.AssertTokensCleared(w)
+
+			if stored, _ := h.Store.ListTokensBySubject("bob"); len(stored) != 0 {
+				t.Errorf("refresh token is still stored: %+v", stored)
+			}
+
+			// the old tokens can't be refreshed once the auth token expires
+			h.Clock.Advance(time.Hour)
+			if options.RefreshEndpoint {
+				w = refresh(h, tokens)
+			} else {
+				w = h.Serve(okHandler, h.NewRequest("GET", "/", tokens))
+			}
+			if w.Code != 401 {
+				t.Errorf("refresh after logout code = %d, want 401", w.Code)
+			}
+		})
+	}
+}
+
+func TestSetTokenStoreSetsClock(t *testing.T) {
+	clock := jwttest.NewFakeClock(time.Now())
+	var auth jwt.Auth
+	if err := jwt.New(&auth, jwt.Options{SigningMethodString: "HS256", HMACKey: []byte("secret"), Clock: clock}); err != nil {
+		t.Fatal(err)
+	}
+	store := jwt.NewMemoryTokenStore(time.Hour)
+	defer store.Close()
+	auth.SetTokenStore(store)
+
+	if err := auth.IssueNewTokens(httptest.NewRecorder(), bob); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := store.ListTokensBySubject("bob"); len(tokens) != 1 {
+		t.Fatalf("stored tokens = %+v", tokens)
+	}
+
+	// the refresh token expires by the Auth's clock, and so does the store's entry
+	clock.Advance(73 * time.Hour)
+	if tokens, _ := store.ListTokensBySubject("bob"); len(tokens) != 0 {
+		t.Errorf("expired tokens are listed: %+v", tokens)
+	}
+}
@@ -0,0 +1,140 @@
+package jwt
+
+import (
+	"context"
+	"time"
+
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+// parseAuthToken verifies the auth token's signature and validates its claims.
+// The claims are returned along with any claims validation error (e.g. ErrTokenExpired),
+// but are nil if the signature couldn't be verified, or if it isn't an auth token.
+// A verify only server with an IntrospectionURL asks the auth server instead, within ctx.
+func (a *Auth) parseAuthToken(ctx context.Context, tokenString string) (Claims, error) {
+	if a.introspectionClient != nil {
+		return a.introspectToken(ctx, tokenString)
+	}
+
+	return a.parseTokenOfType(tokenString, TokenTypeHintAccessToken)
+}
+
+// parseRefreshToken is like parseAuthToken, but for refresh tokens, which are never introspected
+func (a *Auth) parseRefreshToken(tokenString string) (Claims, error) {
+	return a.parseTokenOfType(tokenString, TokenTypeHintRefreshToken)
+}
+
+// parseTokenOfType parses the token (see parseTokenType), and rejects it unless it's of the type
+func (a *Auth) parseTokenOfType(tokenString string, tokenType string) (Claims, error) {
+	claims, typ, err := a.parseTokenType(tokenString)
+	if claims != nil && typ != tokenType {
+		a.myLog("Token is not a " + tokenType + "!")
+		return nil, ErrInvalidToken
+	}
+
+	return claims, err
+}
+
+// parseTokenType verifies the token's signature and validates its claims, like parseAuthToken,
+// but accepts either type of token. It returns whether it's an auth token (TokenTypeHintAccessToken)
+// or a refresh token (TokenTypeHintRefreshToken).
+func (a *Auth) parseTokenType(tokenString string) (Claims, string, error) {
+	// the time based claims are validated below, with leeway
+	parser := jwtGo.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, a.newClaims(), a.keyFunc)
+	if token == nil {
+		return nil, "", ErrMalformedToken
+	}
+	if err != nil {
+		a.myLog(err)
+		return nil, "", tokenError(err)
+	}
+
+	claims := token.Claims.(Claims)
+
+	// every auth and refresh token carries a csrf secret. Other tokens that we sign, i.e. id
+	// tokens, don't, and mustn't be accepted in their place.
+	if claims.Base().Csrf == "" {
+		a.myLog("Token has no csrf secret!")
+		return nil, "", ErrInvalidToken
+	}
+
+	tokenType := a.tokenType(token, claims.Base())
+	if tokenType == "" {
+		a.myLog("Token has an unknown typ!")
+		return nil, "", ErrInvalidToken
+	}
+
+	return claims, tokenType, a.validateClaims(claims.Base())
+}
+
+// tokenType tells auth tokens, which have an "at+jwt" typ header, from refresh tokens, which have a
+// "refresh+jwt" one. It's "" for any other token.
+func (a *Auth) tokenType(token *jwtGo.Token, claims *ClaimsType) string {
+	switch typ, _ := token.Header["typ"].(string); typ {
+	case authTokenType:
+		return TokenTypeHintAccessToken
+	case refreshTokenType:
+		return TokenTypeHintRefreshToken
+	case "", "JWT":
+		// refresh tokens that were issued before they were typed are told apart by their lifetime.
+		// Tokens without an iat, which earlier versions didn't set, have no known lifetime, so
+		// neither they nor untyped auth tokens are accepted.
+		if claims.IssuedAt != 0 && claims.ExpiresAt-claims.IssuedAt > int64(a.options.AuthTokenValidTime.Seconds()) {
+			return TokenTypeHintRefreshToken
+		}
+	}
+	return ""
+}
+
+// validateClaims checks the token's exp, nbf, iat, iss and aud claims against our options
+func (a *Auth) validateClaims(claims *ClaimsType) error {
+	now := a.now()
+	leeway := a.options.Leeway
+
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+
+	if claims.IssuedAt == 0 {
+		if a.options.RequireIssuedAt {
+			return ErrInvalidIssuedAt
+		}
+	} else if now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		// the token was issued in the future
+		return ErrInvalidIssuedAt
+	}
+
+	if a.options.Issuer != "" && claims.Issuer != a.options.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if len(a.options.Audience) > 0 && !stringInSlice(claims.Audience, a.options.Audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+// stampClaims sets the issuer and audience on newly issued claims, unless they are already set
+func (a *Auth) stampClaims(claims *ClaimsType) {
+	if claims.Issuer == "" {
+		claims.Issuer = a.options.Issuer
+	}
+	if claims.Audience == "" && len(a.options.Audience) > 0 {
+		claims.Audience = a.options.Audience[0]
+	}
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, item := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/jwt-auth/jwt"
+	"github.com/adam-hanna/jwt-auth/jwt/jwttest"
+	jwtGo "github.com/dgrijalva/jwt-go"
+)
+
+var transportModes = map[string]jwt.Options{
+	"cookies":              {},
+	"bearer tokens":        {BearerTokens: true},
+	"authorization header": {AuthorizationHeader: true},
+}
+
+func TestRefreshTokenIsNotAnAuthToken(t *testing.T) {
+	for name, options := range transportModes {
+		t.Run(name, func(t *testing.T) {
+			h := jwttest.New(t, options)
+			tokens := h.Issue(bob)
+
+			// the refresh token shares the auth token's csrf secret, so only its type tells them apart
+			tokens.AuthToken = tokens.RefreshToken
+			if w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens)); w.Code != 401 {
+				t.Errorf("code = %d, want 401", w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthTokenIsNotARefreshToken(t *testing.T) {
+	for name, options := range transportModes {
+		t.Run(name, func(t *testing.T) {
+			h := jwttest.New(t, options)
+			tokens := h.Issue(bob)
+			tokens.RefreshToken = tokens.AuthToken
+
+			// the middleware mustn't refresh an expired auth token with another auth token
+			if w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens))); w.Code != 401 {
+				t.Errorf("middleware code = %d, want 401", w.Code)
+			}
+
+			// and neither may the refresh handler
+			if w := refresh(h, tokens); w.Code != 401 {
+				t.Errorf("refresh handler code = %d, want 401", w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthTokenIsNotReSignedAsARefreshToken(t *testing.T) {
+	h := jwttest.New(t)
+	tokens := h.Issue(bob)
+
+	// the refresh token's expiry is extended on every request; an auth token in its place mustn't be
+	tokens.RefreshToken = tokens.AuthToken
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens)); w.Code != 401 {
+		t.Errorf("code = %d, want 401", w.Code)
+	}
+}
+
+func TestRefreshedTokensKeepTheirTypes(t *testing.T) {
+	h := jwttest.New(t)
+	tokens := h.Issue(bob)
+
+	w := h.Serve(okHandler, h.NewRequest("GET", "/", h.ExpireAuthToken(tokens)))
+	if w.Code != 200 {
+		t.Fatalf("code = %d", w.Code)
+	}
+	refreshed := h.Update(tokens, w)
+
+	swapped := refreshed
+	swapped.AuthToken = refreshed.RefreshToken
+	if w = h.Serve(okHandler, h.NewRequest("GET", "/", swapped)); w.Code != 401 {
+		t.Errorf("refreshed refresh token as an auth token code = %d, want 401", w.Code)
+	}
+	if w = h.Serve(okHandler, h.NewRequest("GET", "/", refreshed)); w.Code != 200 {
+		t.Errorf("refreshed tokens code = %d", w.Code)
+	}
+}
+
+func TestUntypedTokens(t *testing.T) {
+	key := []byte("secret")
+	var auth jwt.Auth
+	if err := jwt.New(&auth, jwt.Options{SigningMethodString: "HS256", HMACKey: key, IsDevEnv: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens from earlier versions have no typ header, and the oldest have no iat, either
+	now := time.Now()
+	untyped := func(validTime time.Duration, issuedAt int64) string {
+		claims := bob
+		claims.Csrf = "csrf"
+		claims.Id = "id"
+		claims.ExpiresAt = now.Add(validTime).Unix()
+		claims.IssuedAt = issuedAt
+		signed, err := jwtGo.NewWithClaims(jwtGo.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	for name, test := range map[string]struct {
+		refreshToken string
+		code         int
+	}{
+		"auth token without an iat":    {untyped(15*time.Minute, 0), 401},
+		"refresh token without an iat": {untyped(72*time.Hour, 0), 401},
+		"auth token":                   {untyped(15*time.Minute, now.Unix()), 401},
+		"refresh token":                {untyped(72*time.Hour, now.Unix()), 200},
+	} {
+		r := httptest.NewRequest("POST", "/refresh", nil)
+		r.AddCookie(&http.Cookie{Name: "RefreshToken", Value: test.refreshToken})
+		r.Header.Set("X-CSRF-Token", "csrf")
+		w := httptest.NewRecorder()
+		auth.RefreshHandler().ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s as the refresh token: code = %d, want %d", name, w.Code, test.code)
+		}
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	key := []byte("secret")
+	clock := jwttest.NewFakeClock(time.Unix(1700000000, 0))
+	now := clock.Now()
+	newAuth := func(requireIssuedAt bool) (*jwt.Auth, *error) {
+		var auth jwt.Auth
+		err := jwt.New(&auth, jwt.Options{
+			SigningMethodString: "HS256",
+			HMACKey:             key,
+			AuthorizationHeader: true,
+			IsDevEnv:            true,
+			Clock:               clock,
+			Leeway:              30 * time.Second,
+			Issuer:              "https://auth.example.com",
+			Audience:            []string{"api", "admin"},
+			RequireIssuedAt:     requireIssuedAt,
+			// so that an expired auth token isn't refreshed
+			RefreshEndpoint: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var unauthorizedErr error
+		auth.SetUnauthorizedHandlerWithError(func(w http.ResponseWriter, r *http.Request, err error) {
+			unauthorizedErr = err
+			http.Error(w, "Unauthorized", 401)
+		})
+		return &auth, &unauthorizedErr
+	}
+	strict, strictErr := newAuth(true)
+	lenient, lenientErr := newAuth(false)
+
+	// the tokens are valid, unless changed
+	sign := func(change func(claims *jwt.ClaimsType)) string {
+		claims := bob
+		claims.Csrf = "csrf"
+		claims.Issuer = "https://auth.example.com"
+		claims.Audience = "api"
+		claims.IssuedAt = now.Unix()
+		claims.NotBefore = now.Unix()
+		claims.ExpiresAt = now.Add(15 * time.Minute).Unix()
+		change(&claims)
+
+		token := jwtGo.NewWithClaims(jwtGo.SigningMethodHS256, claims)
+		token.Header["typ"] = "at+jwt"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	seconds := func(s int) int64 {
+		return now.Add(time.Duration(s) * time.Second).Unix()
+	}
+
+	for name, test := range map[string]struct {
+		change  func(claims *jwt.ClaimsType)
+		want    error
+		lenient error
+	}{
+		"valid":                           {func(c *jwt.ClaimsType) {}, nil, nil},
+		"expired, within the leeway":      {func(c *jwt.ClaimsType) { c.ExpiresAt = seconds(-30) }, nil, nil},
+		"expired, outside the leeway":     {func(c *jwt.ClaimsType) { c.ExpiresAt = seconds(-31) }, jwt.ErrTokenExpired, jwt.ErrTokenExpired},
+		"not before, within the leeway":   {func(c *jwt.ClaimsType) { c.NotBefore = seconds(30) }, nil, nil},
+		"not before, outside the leeway":  {func(c *jwt.ClaimsType) { c.NotBefore = seconds(31) }, jwt.ErrTokenNotYetValid, jwt.ErrTokenNotYetValid},
+		"issued in the future, in leeway": {func(c *jwt.ClaimsType) { c.IssuedAt = seconds(30) }, nil, nil},
+		"issued in the future":            {func(c *jwt.ClaimsType) { c.IssuedAt = seconds(31) }, jwt.ErrInvalidIssuedAt, jwt.ErrInvalidIssuedAt},
+		"no iat":                          {func(c *jwt.ClaimsType) { c.IssuedAt = 0 }, jwt.ErrInvalidIssuedAt, nil},
+		"another issuer":                  {func(c *jwt.ClaimsType) { c.Issuer = "https://evil.example.com" }, jwt.ErrInvalidIssuer, jwt.ErrInvalidIssuer},
+		"no issuer":                       {func(c *jwt.ClaimsType) { c.Issuer = "" }, jwt.ErrInvalidIssuer, jwt.ErrInvalidIssuer},
+		"another accepted audience":       {func(c *jwt.ClaimsType) { c.Audience = "admin" }, nil, nil},
+		"another audience":                {func(c *jwt.ClaimsType) { c.Audience = "billing" }, jwt.ErrInvalidAudience, jwt.ErrInvalidAudience},
+	} {
+		authToken := sign(test.change)
+		for _, server := range []struct {
+			auth *jwt.Auth
+			err  *error
+			want error
+		}{
+			{strict, strictErr, test.want},
+			{lenient, lenientErr, test.lenient},
+		} {
+			*server.err = nil
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+authToken)
+			r.Header.Set("X-CSRF-Token", "csrf")
+			w := httptest.NewRecorder()
+			server.auth.Handler(okHandler).ServeHTTP(w, r)
+
+			if server.want == nil && w.Code != 200 {
+				t.Errorf("%s: code = %d, error = %v", name, w.Code, *server.err)
+			} else if server.want != nil && *server.err != server.want {
+				t.Errorf("%s: error = %v, want %v", name, *server.err, server.want)
+			}
+		}
+	}
+}
+
+func TestIssuedTokensAreStamped(t *testing.T) {
+	h := jwttest.New(t, jwt.Options{Issuer: "https://auth.example.com", Audience: []string{"api"}, RequireIssuedAt: true})
+	tokens := h.Issue(bob)
+
+	if w := h.Serve(okHandler, h.NewRequest("GET", "/", tokens)); w.Code != 200 {
+		t.Errorf("code = %d", w.Code)
+	}
+	payload, err := jwtGo.DecodeSegment(strings.Split(tokens.AuthToken, ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != "https://auth.example.com" || claims["aud"] != "api" || claims["iat"] == nil {
+		t.Errorf("claims = %v", claims)
+	}
+}